@@ -0,0 +1,485 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "90s" or "1h"
+// in config files and environment variables.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Database configures the PostgreSQL connection pool shared by both services.
+type Database struct {
+	Host            string   `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port            int      `yaml:"port" toml:"port" env:"DB_PORT"`
+	User            string   `yaml:"user" toml:"user" env:"DB_USER"`
+	Password        string   `yaml:"password" toml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name            string   `yaml:"name" toml:"name" env:"DB_NAME"`
+	SSLMode         string   `yaml:"sslmode" toml:"sslmode" env:"DB_SSLMODE"`
+	SSLRootCert     string   `yaml:"sslrootcert" toml:"sslrootcert" env:"DB_SSLROOTCERT"`
+	ConnectTimeout  Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// DSN returns the connection string for lib/pq.
+func (d Database) DSN() string {
+	params := []string{
+		"host=" + quoteDSN(d.Host),
+		fmt.Sprintf("port=%d", d.Port),
+		"user=" + quoteDSN(d.User),
+		"password=" + quoteDSN(d.Password),
+		"dbname=" + quoteDSN(d.Name),
+		"sslmode=" + quoteDSN(d.SSLMode),
+	}
+	if d.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+quoteDSN(d.SSLRootCert))
+	}
+	if d.ConnectTimeout > 0 {
+		params = append(params, fmt.Sprintf("connect_timeout=%d", int(time.Duration(d.ConnectTimeout).Seconds())))
+	}
+	return strings.Join(params, " ")
+}
+
+func quoteDSN(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+func (d Database) validate() error {
+	var errs []error
+	if d.Host == "" {
+		errs = append(errs, errors.New("database.host is required"))
+	}
+	if d.Port < 1 || d.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database.port %d is out of range", d.Port))
+	}
+	if d.User == "" || d.Password == "" || d.Name == "" {
+		errs = append(errs, errors.New("database.user, database.password and database.name are required"))
+	}
+	if !slices.Contains(sslModes, d.SSLMode) {
+		errs = append(errs, fmt.Errorf("database.sslmode must be one of %s", strings.Join(sslModes, ", ")))
+	}
+	if d.MaxOpenConns < 0 || d.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database pool sizes must not be negative"))
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		errs = append(errs, errors.New("database.max_idle_conns must not exceed database.max_open_conns"))
+	}
+	if d.ConnMaxLifetime < 0 || d.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("database durations must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// HistoryClient configures how location-management reaches location-history.
+type HistoryClient struct {
+	Target     string `yaml:"target" toml:"target" env:"HISTORY_TARGET"`
+	CAFile     string `yaml:"tls_ca" toml:"tls_ca" env:"HISTORY_TLS_CA"`
+	CertFile   string `yaml:"tls_cert" toml:"tls_cert" env:"HISTORY_TLS_CERT"`
+	KeyFile    string `yaml:"tls_key" toml:"tls_key" env:"HISTORY_TLS_KEY"`
+	ServerName string `yaml:"tls_server_name" toml:"tls_server_name" env:"HISTORY_TLS_SERVER_NAME"`
+	CallToken  string `yaml:"call_token" toml:"call_token" env:"HISTORY_CALL_TOKEN" secret:"true"`
+
+	// CallTimeout bounds each attempt; the caller's context still bounds
+	// the call as a whole, retries included.
+	CallTimeout     Duration `yaml:"call_timeout" toml:"call_timeout" env:"HISTORY_CALL_TIMEOUT"`
+	MaxAttempts     int      `yaml:"max_attempts" toml:"max_attempts" env:"HISTORY_MAX_ATTEMPTS"`
+	RetryBackoff    Duration `yaml:"retry_backoff" toml:"retry_backoff" env:"HISTORY_RETRY_BACKOFF"`
+	MaxRetryBackoff Duration `yaml:"max_retry_backoff" toml:"max_retry_backoff" env:"HISTORY_MAX_RETRY_BACKOFF"`
+	// After BreakerThreshold consecutive failures calls fail fast for
+	// BreakerCooldown, then a single call probes whether history is back.
+	BreakerThreshold int      `yaml:"breaker_threshold" toml:"breaker_threshold" env:"HISTORY_BREAKER_THRESHOLD"`
+	BreakerCooldown  Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown" env:"HISTORY_BREAKER_COOLDOWN"`
+}
+
+func defaultHistoryClient() HistoryClient {
+	return HistoryClient{
+		Target:           "localhost:50051",
+		CallTimeout:      Duration(2 * time.Second),
+		MaxAttempts:      3,
+		RetryBackoff:     Duration(100 * time.Millisecond),
+		MaxRetryBackoff:  Duration(time.Second),
+		BreakerThreshold: 5,
+		BreakerCooldown:  Duration(30 * time.Second),
+	}
+}
+
+func (h HistoryClient) validate() error {
+	var errs []error
+	if err := validateAddr("history.target", h.Target, true); err != nil {
+		errs = append(errs, err)
+	}
+	if h.CAFile == "" && (h.CertFile != "" || h.KeyFile != "" || h.CallToken != "") {
+		errs = append(errs, errors.New("history.tls_ca is required for client certificates and call tokens"))
+	}
+	if (h.CertFile == "") != (h.KeyFile == "") {
+		errs = append(errs, errors.New("history.tls_cert and history.tls_key must be set together"))
+	}
+	if h.CallTimeout <= 0 || h.RetryBackoff <= 0 || h.BreakerCooldown <= 0 {
+		errs = append(errs, errors.New("history.call_timeout, history.retry_backoff and history.breaker_cooldown must be positive"))
+	}
+	if h.MaxRetryBackoff < h.RetryBackoff {
+		errs = append(errs, errors.New("history.max_retry_backoff must not be less than history.retry_backoff"))
+	}
+	if h.MaxAttempts < 1 || h.BreakerThreshold < 1 {
+		errs = append(errs, errors.New("history.max_attempts and history.breaker_threshold must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+)
+
+// Tracing configures OpenTelemetry trace export. The OTLP exporter sends
+// spans over gRPC to Endpoint; the stdout exporter writes them to File, or
+// to standard output when File is empty.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_OTLP_INSECURE"`
+	File        string  `yaml:"file" toml:"file" env:"TRACING_FILE"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+func (t Tracing) validate() error {
+	var errs []error
+	if t.Exporter != TracingNone && t.Exporter != TracingOTLP && t.Exporter != TracingStdout {
+		errs = append(errs, fmt.Errorf("tracing.exporter must be one of %s, %s, %s", TracingNone, TracingOTLP, TracingStdout))
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultTracing() Tracing {
+	return Tracing{Exporter: TracingNone, SampleRatio: 1}
+}
+
+// Logging configures the JSON logs. Level is debug, info, warn or error.
+type Logging struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+}
+
+func (l Logging) validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return fmt.Errorf("logging.level: %v", err)
+	}
+	return nil
+}
+
+// Auth configures JWT and API key authentication.
+type Auth struct {
+	HS256Secret     string  `yaml:"hs256_secret" toml:"hs256_secret" env:"JWT_HS256_SECRET" secret:"true"`
+	JWKSFile        string  `yaml:"jwks_file" toml:"jwks_file" env:"JWT_JWKS_FILE"`
+	Issuer          string  `yaml:"issuer" toml:"issuer" env:"JWT_ISSUER"`
+	Audience        string  `yaml:"audience" toml:"audience" env:"JWT_AUDIENCE"`
+	APIKeyRateLimit float64 `yaml:"api_key_rate_limit" toml:"api_key_rate_limit" env:"API_KEY_RATE_LIMIT"`
+	APIKeyBurst     int     `yaml:"api_key_burst" toml:"api_key_burst" env:"API_KEY_BURST"`
+}
+
+func (a Auth) validate() error {
+	var errs []error
+	if a.HS256Secret == "" && a.JWKSFile == "" {
+		errs = append(errs, errors.New("auth.hs256_secret or auth.jwks_file is required"))
+	}
+	if a.APIKeyRateLimit <= 0 || a.APIKeyBurst <= 0 {
+		errs = append(errs, errors.New("auth.api_key_rate_limit and auth.api_key_burst must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// Management is the configuration of the location-management service.
+type Management struct {
+	ListenAddr        string        `yaml:"listen_addr" toml:"listen_addr" env:"HTTP_LISTEN_ADDR"`
+	Database          Database      `yaml:"database" toml:"database"`
+	History           HistoryClient `yaml:"history" toml:"history"`
+	Auth              Auth          `yaml:"auth" toml:"auth"`
+	Live              Live          `yaml:"live" toml:"live"`
+	Imports           Imports       `yaml:"imports" toml:"imports"`
+	Tracing           Tracing       `yaml:"tracing" toml:"tracing"`
+	Logging           Logging       `yaml:"logging" toml:"logging"`
+	PrivacySecret     string        `yaml:"privacy_secret" toml:"privacy_secret" env:"PRIVACY_SECRET" secret:"true"`
+	ReceiptSigningKey string        `yaml:"receipt_signing_key" toml:"receipt_signing_key" env:"RECEIPT_SIGNING_KEY" secret:"true"`
+	ShutdownTimeout   Duration      `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	LegacySunset      string        `yaml:"legacy_sunset" toml:"legacy_sunset" env:"LEGACY_SUNSET"`
+	// OsmAnd enables the unauthenticated tracker endpoint, where a
+	// registered device ID is the only credential.
+	OsmAnd bool `yaml:"osmand" toml:"osmand" env:"OSMAND_ENABLED"`
+	// TrustedProxies are the IPs or CIDRs whose X-Forwarded-For header is
+	// believed. With none, the client IP is the peer address.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// DocsAssetsDir holds the swagger-ui-dist files /docs serves. With
+	// none, /docs is disabled.
+	DocsAssetsDir string `yaml:"docs_assets_dir" toml:"docs_assets_dir" env:"DOCS_ASSETS_DIR"`
+}
+
+// Live configures the WebSocket live map feed. Interval is the shortest time
+// between two position messages on one connection.
+type Live struct {
+	Interval       Duration `yaml:"interval" toml:"interval" env:"LIVE_INTERVAL"`
+	MaxConnections int      `yaml:"max_connections" toml:"max_connections" env:"LIVE_MAX_CONNECTIONS"`
+}
+
+func (l Live) validate() error {
+	var errs []error
+	if l.Interval <= 0 {
+		errs = append(errs, errors.New("live.interval must be positive"))
+	}
+	if l.MaxConnections < 1 {
+		errs = append(errs, errors.New("live.max_connections must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Imports configures location history import jobs. Uploads are spooled to
+// Dir, the system temp directory when empty, and may be at most MaxMB
+// megabytes. At most MaxJobs imports run at once, and one per user.
+type Imports struct {
+	Dir     string `yaml:"dir" toml:"dir" env:"IMPORT_DIR"`
+	MaxMB   int    `yaml:"max_mb" toml:"max_mb" env:"IMPORT_MAX_MB"`
+	MaxJobs int    `yaml:"max_jobs" toml:"max_jobs" env:"IMPORT_MAX_JOBS"`
+}
+
+func (i Imports) validate() error {
+	var errs []error
+	if i.MaxMB < 1 {
+		errs = append(errs, errors.New("imports.max_mb must be at least 1"))
+	}
+	if i.MaxJobs < 1 {
+		errs = append(errs, errors.New("imports.max_jobs must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultManagement returns the settings used for anything a config file or
+// the environment does not set.
+func DefaultManagement() *Management {
+	return &Management{
+		ListenAddr:      ":8080",
+		Database:        defaultDatabase(),
+		History:         defaultHistoryClient(),
+		Auth:            Auth{APIKeyRateLimit: 10, APIKeyBurst: 20},
+		Live:            Live{Interval: Duration(time.Second), MaxConnections: 1000},
+		Imports:         Imports{MaxMB: 1024, MaxJobs: 4},
+		Tracing:         defaultTracing(),
+		Logging:         Logging{Level: "info"},
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (m *Management) Validate() error {
+	var errs []error
+	if err := validateAddr("listen_addr", m.ListenAddr, false); err != nil {
+		errs = append(errs, err)
+	}
+	if m.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if m.LegacySunset != "" {
+		if _, err := time.Parse(time.DateOnly, m.LegacySunset); err != nil {
+			errs = append(errs, fmt.Errorf("legacy_sunset %q is not a date like 2006-01-02", m.LegacySunset))
+		}
+	}
+	if m.ReceiptSigningKey == "" {
+		errs = append(errs, errors.New("receipt_signing_key is required to sign deletion receipts"))
+	}
+	for _, proxy := range m.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an IP or CIDR", proxy))
+		}
+	}
+	errs = append(errs, m.Database.validate(), m.History.validate(), m.Auth.validate(), m.Live.validate(), m.Imports.validate(), m.Tracing.validate(), m.Logging.validate())
+	return errors.Join(errs...)
+}
+
+// ServerTLS configures TLS and caller checks on the location-history server.
+type ServerTLS struct {
+	CertFile       string   `yaml:"cert" toml:"cert" env:"GRPC_TLS_CERT"`
+	KeyFile        string   `yaml:"key" toml:"key" env:"GRPC_TLS_KEY"`
+	ClientCAFile   string   `yaml:"client_ca" toml:"client_ca" env:"GRPC_TLS_CLIENT_CA"`
+	AllowedClients []string `yaml:"allowed_clients" toml:"allowed_clients" env:"GRPC_ALLOWED_CLIENTS"`
+	CallToken      string   `yaml:"call_token" toml:"call_token" env:"GRPC_CALL_TOKEN" secret:"true"`
+}
+
+func (t ServerTLS) validate() error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert and tls.key must be set together"))
+	}
+	if t.CertFile == "" && (t.ClientCAFile != "" || t.CallToken != "") {
+		errs = append(errs, errors.New("tls.cert and tls.key are required for client verification"))
+	}
+	if len(t.AllowedClients) > 0 && t.ClientCAFile == "" {
+		errs = append(errs, errors.New("tls.allowed_clients requires tls.client_ca"))
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	RetentionDelete    = "delete"
+	RetentionAggregate = "aggregate"
+)
+
+// Retention configures the history purge job. Days of 0 keeps points
+// forever unless a per-user override applies.
+type Retention struct {
+	Days      int      `yaml:"days" toml:"days" env:"RETENTION_DAYS"`
+	Mode      string   `yaml:"mode" toml:"mode" env:"RETENTION_MODE"`
+	BatchSize int      `yaml:"batch_size" toml:"batch_size" env:"RETENTION_BATCH_SIZE"`
+	Interval  Duration `yaml:"interval" toml:"interval" env:"RETENTION_INTERVAL"`
+}
+
+func (r Retention) validate() error {
+	var errs []error
+	if r.Days < 0 {
+		errs = append(errs, errors.New("retention.days must not be negative"))
+	}
+	if r.Mode != RetentionDelete && r.Mode != RetentionAggregate {
+		errs = append(errs, fmt.Errorf("retention.mode must be %s or %s", RetentionDelete, RetentionAggregate))
+	}
+	if r.BatchSize <= 0 {
+		errs = append(errs, errors.New("retention.batch_size must be positive"))
+	}
+	if r.Interval <= 0 {
+		errs = append(errs, errors.New("retention.interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// NMEA configures the TCP listener for GPS units sending raw NMEA 0183. An
+// empty ListenAddr disables it. It uses TLS when the server certificate is
+// set. Connections without a sentence for IdleTimeout are closed.
+type NMEA struct {
+	ListenAddr  string   `yaml:"listen_addr" toml:"listen_addr" env:"NMEA_LISTEN_ADDR"`
+	IdleTimeout Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"NMEA_IDLE_TIMEOUT"`
+}
+
+func (n NMEA) validate() error {
+	var errs []error
+	if n.ListenAddr != "" {
+		if err := validateAddr("nmea.listen_addr", n.ListenAddr, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("nmea.idle_timeout must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// History is the configuration of the location-history service.
+type History struct {
+	ListenAddr  string    `yaml:"listen_addr" toml:"listen_addr" env:"GRPC_LISTEN_ADDR"`
+	MetricsAddr string    `yaml:"metrics_addr" toml:"metrics_addr" env:"METRICS_LISTEN_ADDR"`
+	Database    Database  `yaml:"database" toml:"database"`
+	TLS         ServerTLS `yaml:"tls" toml:"tls"`
+	Retention   Retention `yaml:"retention" toml:"retention"`
+	NMEA        NMEA      `yaml:"nmea" toml:"nmea"`
+	Tracing     Tracing   `yaml:"tracing" toml:"tracing"`
+	Logging     Logging   `yaml:"logging" toml:"logging"`
+
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// DefaultHistory returns the settings used for anything a config file or
+// the environment does not set.
+func DefaultHistory() *History {
+	return &History{
+		ListenAddr:  ":50051",
+		MetricsAddr: ":9090",
+		Database:    defaultDatabase(),
+		Retention: Retention{
+			Mode:      RetentionDelete,
+			BatchSize: 1000,
+			Interval:  Duration(time.Hour),
+		},
+		NMEA:            NMEA{IdleTimeout: Duration(5 * time.Minute)},
+		Tracing:         defaultTracing(),
+		Logging:         Logging{Level: "info"},
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (h *History) Validate() error {
+	var errs []error
+	if err := validateAddr("listen_addr", h.ListenAddr, false); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateAddr("metrics_addr", h.MetricsAddr, false); err != nil {
+		errs = append(errs, err)
+	}
+	if h.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	errs = append(errs, h.Database.validate(), h.TLS.validate(), h.Retention.validate(), h.NMEA.validate(), h.Tracing.validate(), h.Logging.validate())
+	return errors.Join(errs...)
+}
+
+// defaultShutdownTimeout bounds how long in-flight requests may take to
+// finish after a termination signal.
+const defaultShutdownTimeout = Duration(15 * time.Second)
+
+func defaultDatabase() Database {
+	return Database{
+		Host:            "localhost",
+		Port:            5432,
+		SSLMode:         "disable",
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: Duration(30 * time.Minute),
+	}
+}
+
+// validateAddr checks a host:port address. Listen addresses may leave the
+// host empty; dial targets may also use a gRPC resolver scheme.
+func validateAddr(name, addr string, dial bool) error {
+	if dial {
+		if u, err := url.Parse(addr); err == nil && u.Scheme != "" && u.Opaque == "" && u.Host == "" && u.Path != "" {
+			return nil
+		}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if dial && host == "" {
+		return fmt.Errorf("%s: host is required", name)
+	}
+	if port == "" {
+		return fmt.Errorf("%s: port is required", name)
+	}
+	return nil
+}
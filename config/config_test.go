@@ -0,0 +1,164 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadManagementYAML(t *testing.T) {
+	path := writeConfig(t, "management.yaml", `
+listen_addr: ":9090"
+database:
+  host: db.internal
+  user: app
+  password: s3cret
+  name: locations
+  sslmode: require
+  max_open_conns: 20
+history:
+  target: history.internal:50051
+  call_timeout: 500ms
+auth:
+  hs256_secret: jwt-secret
+osmand: true
+receipt_signing_key: AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+trusted_proxies: ["10.0.0.0/8", "192.0.2.1"]
+docs_assets_dir: /usr/share/swagger-ui
+`)
+
+	cfg, err := LoadManagement(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.ListenAddr)
+	assert.Equal(t, "db.internal", cfg.Database.Host)
+	assert.Equal(t, 5432, cfg.Database.Port)
+	assert.Equal(t, 20, cfg.Database.MaxOpenConns)
+	assert.Equal(t, Duration(30*time.Minute), cfg.Database.ConnMaxLifetime)
+	assert.Equal(t, "history.internal:50051", cfg.History.Target)
+	assert.Equal(t, Duration(500*time.Millisecond), cfg.History.CallTimeout)
+	assert.Equal(t, 3, cfg.History.MaxAttempts)
+	assert.Equal(t, 10.0, cfg.Auth.APIKeyRateLimit)
+	assert.True(t, cfg.OsmAnd)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, cfg.TrustedProxies)
+	assert.Equal(t, "/usr/share/swagger-ui", cfg.DocsAssetsDir)
+	assert.Equal(t, 1024, cfg.Imports.MaxMB)
+	assert.Equal(t, 4, cfg.Imports.MaxJobs)
+	assert.Equal(t, "host=db.internal port=5432 user=app password=s3cret dbname=locations sslmode=require", cfg.Database.DSN())
+}
+
+func TestLoadHistoryTOML(t *testing.T) {
+	path := writeConfig(t, "history.toml", `
+listen_addr = ":6000"
+
+[database]
+user = "app"
+password = "s3cret"
+name = "locations"
+
+[tls]
+cert = "server.pem"
+key = "server-key.pem"
+client_ca = "ca.pem"
+allowed_clients = ["location-management"]
+
+[retention]
+days = 30
+interval = "15m"
+
+[nmea]
+listen_addr = ":5010"
+`)
+
+	cfg, err := LoadHistory(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":6000", cfg.ListenAddr)
+	assert.Equal(t, []string{"location-management"}, cfg.TLS.AllowedClients)
+	assert.Equal(t, 30, cfg.Retention.Days)
+	assert.Equal(t, RetentionDelete, cfg.Retention.Mode)
+	assert.Equal(t, Duration(15*time.Minute), cfg.Retention.Interval)
+	assert.Equal(t, ":5010", cfg.NMEA.ListenAddr)
+	assert.Equal(t, Duration(5*time.Minute), cfg.NMEA.IdleTimeout)
+	assert.Equal(t, Duration(15*time.Second), cfg.ShutdownTimeout)
+	assert.Equal(t, TracingNone, cfg.Tracing.Exporter)
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	t.Setenv("DB_USER", "envuser")
+	t.Setenv("DB_PASSWORD", "envpass")
+	t.Setenv("DB_NAME", "envdb")
+	t.Setenv("DB_PORT", "6543")
+	t.Setenv("RETENTION_INTERVAL", "2h")
+	t.Setenv("GRPC_ALLOWED_CLIENTS", "a, b")
+	t.Setenv("GRPC_TLS_CERT", "server.pem")
+	t.Setenv("GRPC_TLS_KEY", "server-key.pem")
+	t.Setenv("GRPC_TLS_CLIENT_CA", "ca.pem")
+	t.Setenv("SHUTDOWN_TIMEOUT", "30s")
+
+	cfg, err := LoadHistory("")
+	assert.NoError(t, err)
+	assert.Equal(t, "envuser", cfg.Database.User)
+	assert.Equal(t, 6543, cfg.Database.Port)
+	assert.Equal(t, Duration(2*time.Hour), cfg.Retention.Interval)
+	assert.Equal(t, []string{"a", "b"}, cfg.TLS.AllowedClients)
+	assert.Equal(t, Duration(30*time.Second), cfg.ShutdownTimeout)
+
+	t.Setenv("DB_PORT", "not-a-port")
+	_, err = LoadHistory("")
+	assert.ErrorContains(t, err, "DB_PORT")
+}
+
+func TestValidation(t *testing.T) {
+	path := writeConfig(t, "management.yaml", `
+listen_addr: "8080"
+database:
+  user: app
+  password: s3cret
+  name: locations
+  sslmode: sometimes
+  max_open_conns: 2
+  max_idle_conns: 5
+history:
+  target: ":50051"
+legacy_sunset: next spring
+trusted_proxies: ["10.0.0.0/33"]
+`)
+
+	_, err := LoadManagement(path)
+	assert.Error(t, err)
+	for _, msg := range []string{"listen_addr", "sslmode", "max_idle_conns", "history.target", "auth.hs256_secret", "legacy_sunset", "trusted_proxies", "receipt_signing_key"} {
+		assert.ErrorContains(t, err, msg)
+	}
+
+	_, err = LoadManagement(writeConfig(t, "management.yaml", "unknown_key: 1\n"))
+	assert.ErrorContains(t, err, "unknown_key")
+
+	_, err = LoadManagement(writeConfig(t, "management.json", "{}"))
+	assert.ErrorContains(t, err, "unsupported config file type")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := DefaultManagement()
+	cfg.Database.Password = "db-password"
+	cfg.Auth.HS256Secret = "jwt-secret"
+
+	out := Redacted(cfg)
+	assert.NotContains(t, out, "db-password")
+	assert.NotContains(t, out, "jwt-secret")
+	assert.Contains(t, out, "password: REDACTED")
+	assert.Contains(t, out, "conn_max_lifetime: 30m0s")
+	// Unset secrets stay empty so it is visible that they are missing
+	assert.True(t, strings.Contains(out, `call_token: ""`))
+
+	// The original is left untouched
+	assert.Equal(t, "db-password", cfg.Database.Password)
+}
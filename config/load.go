@@ -0,0 +1,167 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// LoadManagement builds the location-management configuration from the
+// defaults, the YAML or TOML file at path (if any) and environment
+// overrides, and validates the result.
+func LoadManagement(path string) (*Management, error) {
+	cfg := DefaultManagement()
+	if err := load(path, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadHistory builds the location-history configuration from the defaults,
+// the YAML or TOML file at path (if any) and environment overrides, and
+// validates the result.
+func LoadHistory(path string) (*History, error) {
+	cfg := DefaultHistory()
+	if err := load(path, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func load(path string, cfg interface{}) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+		case ".toml":
+			dec := toml.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(cfg); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+		default:
+			return fmt.Errorf("unsupported config file type %q", filepath.Ext(path))
+		}
+	}
+	return applyEnv(reflect.ValueOf(cfg).Elem())
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// applyEnv overrides every field tagged with env whose variable is set.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("env")
+		value, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// Redacted renders cfg as YAML with every secret that is set replaced by
+// REDACTED, for logging the effective configuration.
+func Redacted(cfg interface{}) string {
+	v := reflect.New(reflect.TypeOf(cfg).Elem())
+	v.Elem().Set(reflect.ValueOf(cfg).Elem())
+	redact(v.Elem())
+
+	out, err := yaml.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprintf("<failed to render config: %v>", err)
+	}
+	return string(out)
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redact(field)
+			continue
+		}
+		if t.Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redacted)
+		}
+	}
+}
@@ -1,32 +1,45 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
-	"os"
+	"database/sql/driver"
+	"time"
 
+	"github.com/XSAM/otelsql"
+	"github.com/abotoiGrid/Golang-Project/config"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var DB *sql.DB
 
-func InitDB() {
+// InitDB opens the connection pool described by cfg and checks that the
+// database is reachable.
+func InitDB(cfg config.Database) error {
 	var err error
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-
-	if dbUser == "" || dbPassword == "" || dbName == "" {
-		log.Fatal("Database credentials are missing!")
-	}
-	connStr := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", dbUser, dbPassword, dbName)
-	DB, err = sql.Open("postgres", connStr)
+	DB, err = otelsql.Open("postgres", cfg.DSN(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter:           inTrace,
+		}),
+	)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	DB.SetMaxOpenConns(cfg.MaxOpenConns)
+	DB.SetMaxIdleConns(cfg.MaxIdleConns)
+	DB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
 
-	if err = DB.Ping(); err != nil {
-		log.Fatal(err)
-	}
+	return DB.Ping()
+}
+
+// inTrace only records statements made on behalf of a traced request, so
+// startup migrations and background jobs don't produce orphan root spans.
+func inTrace(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
 }
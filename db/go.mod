@@ -1,5 +1,24 @@
-module db
+module github.com/abotoiGrid/Golang-Project/db
 
 go 1.23.2
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/XSAM/otelsql v0.35.0
+	github.com/abotoiGrid/Golang-Project/config v0.0.0-00010101000000-000000000000
+	github.com/lib/pq v1.10.9
+	go.opentelemetry.io/otel v1.31.0
+	go.opentelemetry.io/otel/trace v1.31.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
+	github.com/rogpeppe/go-internal v1.13.1 // indirect
+	go.opentelemetry.io/otel/metric v1.31.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/abotoiGrid/Golang-Project/config => ../config
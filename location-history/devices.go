@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errUnknownDevice is returned by deviceUsername for unregistered devices.
+var errUnknownDevice = errors.New("unknown device")
+
+func ensureDevicesTable() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS devices (
+            device_id TEXT PRIMARY KEY,
+            username TEXT NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX IF NOT EXISTS devices_username_idx ON devices (username)`)
+	return err
+}
+
+// deviceUsername returns the user a device reports for.
+func deviceUsername(ctx context.Context, deviceID string) (string, error) {
+	var username string
+	err := db.DB.QueryRowContext(ctx, "SELECT username FROM devices WHERE device_id = $1", deviceID).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errUnknownDevice
+	}
+	return username, err
+}
+
+// RegisterDevice assigns a device to a user. Registering a device the user
+// already owns returns the existing registration.
+func (s *server) RegisterDevice(ctx context.Context, req *pb.RegisterDeviceRequest) (*pb.Device, error) {
+	var username string
+	var createdAt time.Time
+	err := db.DB.QueryRowContext(ctx, `
+        INSERT INTO devices (device_id, username) VALUES ($1, $2)
+        ON CONFLICT (device_id) DO UPDATE SET device_id = EXCLUDED.device_id
+        RETURNING username, created_at`,
+		req.GetDeviceId(), req.GetUsername()).Scan(&username, &createdAt)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to register device: %v", err)
+	}
+	if username != req.GetUsername() {
+		return nil, status.Error(codes.AlreadyExists, "device is registered to another user")
+	}
+	return &pb.Device{DeviceId: req.GetDeviceId(), Username: username, CreatedAt: timestamppb.New(createdAt)}, nil
+}
+
+// ListDevices returns the devices of a user, oldest first.
+func (s *server) ListDevices(ctx context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT device_id, created_at FROM devices WHERE username = $1 ORDER BY created_at, device_id",
+		req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list devices: %v", err)
+	}
+	defer rows.Close()
+
+	resp := &pb.ListDevicesResponse{}
+	for rows.Next() {
+		d := &pb.Device{Username: req.GetUsername()}
+		var createdAt time.Time
+		if err := rows.Scan(&d.DeviceId, &createdAt); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list devices: %v", err)
+		}
+		d.CreatedAt = timestamppb.New(createdAt)
+		resp.Devices = append(resp.Devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list devices: %v", err)
+	}
+	return resp, nil
+}
+
+// GetDevice returns the registration of a device.
+func (s *server) GetDevice(ctx context.Context, req *pb.GetDeviceRequest) (*pb.Device, error) {
+	d := &pb.Device{DeviceId: req.GetDeviceId()}
+	var createdAt time.Time
+	err := db.DB.QueryRowContext(ctx, "SELECT username, created_at FROM devices WHERE device_id = $1",
+		req.GetDeviceId()).Scan(&d.Username, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "device not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get device: %v", err)
+	}
+	d.CreatedAt = timestamppb.New(createdAt)
+	return d, nil
+}
+
+// DeleteDevice removes a device of a user. Devices of other users are
+// reported as not found.
+func (s *server) DeleteDevice(ctx context.Context, req *pb.DeleteDeviceRequest) (*pb.DeleteDeviceResponse, error) {
+	res, err := db.DB.ExecContext(ctx, "DELETE FROM devices WHERE device_id = $1 AND username = $2",
+		req.GetDeviceId(), req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete device: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Error(codes.NotFound, "device not found")
+	}
+	return &pb.DeleteDeviceResponse{}, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// erasableTables lists every history table holding per-user rows.
+var erasableTables = []string{
+	"user_locations",
+	"user_location_daily",
+	"retention_overrides",
+	"devices",
+}
+
+// EraseUser deletes everything the history service stores about a user in
+// a single transaction.
+func (s *server) EraseUser(ctx context.Context, req *pb.EraseUserRequest) (*pb.EraseUserResponse, error) {
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	deleted := make(map[string]int64)
+	for _, table := range erasableTables {
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE username = $1", req.Username)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to erase %s: %v", table, err)
+		}
+		deleted[table], _ = res.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit erasure: %v", err)
+	}
+	return &pb.EraseUserResponse{DeletedRows: deleted}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// exportBatch bounds the locations sent in one ExportUser chunk.
+const exportBatch = 1000
+
+// ExportUser streams what the history service stores about a user, from
+// the same tables EraseUser deletes from.
+func (s *server) ExportUser(req *pb.ExportUserRequest, stream grpc.ServerStreamingServer[pb.ExportUserChunk]) error {
+	if err := req.Validate(); err != nil {
+		return validation.Status(err)
+	}
+	ctx := stream.Context()
+	username := req.GetUsername()
+
+	first := &pb.ExportUserChunk{}
+	devices, err := s.ListDevices(ctx, &pb.ListDevicesRequest{Username: username})
+	if err != nil {
+		return err
+	}
+	first.Devices = devices.GetDevices()
+
+	var retainDays int32
+	err = db.DB.QueryRowContext(ctx, "SELECT retain_days FROM retention_overrides WHERE username = $1",
+		username).Scan(&retainDays)
+	switch {
+	case err == nil:
+		first.RetainDays = &retainDays
+	case !errors.Is(err, sql.ErrNoRows):
+		return status.Errorf(codes.Internal, "failed to export retention override: %v", err)
+	}
+
+	first.Daily, err = exportDaily(ctx, username)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to export daily summaries: %v", err)
+	}
+	if err := stream.Send(first); err != nil {
+		return err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT latitude, longitude, timestamp, accuracy, altitude, speed
+        FROM user_locations
+        WHERE username = $1
+        ORDER BY timestamp`,
+		username)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to export locations: %v", err)
+	}
+	defer rows.Close()
+
+	chunk := &pb.ExportUserChunk{}
+	for rows.Next() {
+		l := &pb.HistoryLocation{}
+		var timestamp time.Time
+		if err := rows.Scan(&l.Latitude, &l.Longitude, &timestamp, &l.Accuracy, &l.Altitude, &l.Speed); err != nil {
+			return status.Errorf(codes.Internal, "failed to export locations: %v", err)
+		}
+		l.Timestamp = timestamp.Unix()
+		chunk.Locations = append(chunk.Locations, l)
+		if len(chunk.Locations) == exportBatch {
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &pb.ExportUserChunk{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return status.Errorf(codes.Internal, "failed to export locations: %v", err)
+	}
+	if len(chunk.Locations) > 0 {
+		return stream.Send(chunk)
+	}
+	return nil
+}
+
+func exportDaily(ctx context.Context, username string) ([]*pb.DailySummary, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT day, points, avg_latitude, avg_longitude, first_seen, last_seen
+        FROM user_location_daily
+        WHERE username = $1
+        ORDER BY day`,
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var daily []*pb.DailySummary
+	for rows.Next() {
+		d := &pb.DailySummary{}
+		var day, firstSeen, lastSeen time.Time
+		if err := rows.Scan(&day, &d.Points, &d.AvgLatitude, &d.AvgLongitude, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		d.Day = day.Format(time.DateOnly)
+		d.FirstSeen = timestamppb.New(firstSeen)
+		d.LastSeen = timestamppb.New(lastSeen)
+		daily = append(daily, d)
+	}
+	return daily, rows.Err()
+}
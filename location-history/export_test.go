@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type exportStream struct {
+	grpc.ServerStream
+	chunks []*pb.ExportUserChunk
+}
+
+func (s *exportStream) Context() context.Context { return context.Background() }
+
+func (s *exportStream) Send(c *pb.ExportUserChunk) error {
+	s.chunks = append(s.chunks, c)
+	return nil
+}
+
+func TestExportUser(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+	db.DB = testDB
+	assert.NoError(t, ensureRetentionTables())
+	assert.NoError(t, ensureLocationColumns())
+	assert.NoError(t, ensureLocationIndex())
+	assert.NoError(t, ensureDevicesTable())
+	for _, table := range erasableTables {
+		_, err := testDB.Exec("DELETE FROM "+table+" WHERE username = $1", "exportuser")
+		assert.NoError(t, err)
+	}
+
+	s := &server{db: testDB}
+	start := time.Now().Add(-time.Hour).Unix()
+	_, err := s.ImportLocations(context.Background(), &pb.ImportLocationsRequest{
+		Username: "exportuser",
+		Locations: []*pb.ImportedLocation{
+			{Latitude: 1, Longitude: 1, Timestamp: start},
+			{Latitude: 2, Longitude: 2, Timestamp: start + 60, Speed: proto.Float64(3)},
+		},
+	})
+	assert.NoError(t, err)
+	_, err = s.RegisterDevice(context.Background(), &pb.RegisterDeviceRequest{Username: "exportuser", DeviceId: "export-unit"})
+	assert.NoError(t, err)
+	_, err = testDB.Exec("INSERT INTO retention_overrides (username, retain_days) VALUES ($1, 7)", "exportuser")
+	assert.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO user_location_daily (username, day, points, avg_latitude, avg_longitude, first_seen, last_seen)
+        VALUES ($1, '2023-01-01', 10, 1.5, 1.5, '2023-01-01T08:00:00Z', '2023-01-01T18:00:00Z')`, "exportuser")
+	assert.NoError(t, err)
+
+	stream := &exportStream{}
+	assert.NoError(t, s.ExportUser(&pb.ExportUserRequest{Username: "exportuser"}, stream))
+	assert.Len(t, stream.chunks, 2)
+
+	first := stream.chunks[0]
+	assert.Len(t, first.GetDevices(), 1)
+	assert.Equal(t, "export-unit", first.GetDevices()[0].GetDeviceId())
+	assert.Equal(t, int32(7), first.GetRetainDays())
+	assert.Len(t, first.GetDaily(), 1)
+	assert.Equal(t, "2023-01-01", first.GetDaily()[0].GetDay())
+	assert.Empty(t, first.GetLocations())
+
+	locations := stream.chunks[1].GetLocations()
+	assert.Len(t, locations, 2)
+	assert.Equal(t, start, locations[0].GetTimestamp())
+	assert.Nil(t, locations[0].Speed)
+	assert.Equal(t, 3.0, locations[1].GetSpeed())
+
+	err = s.ExportUser(&pb.ExportUserRequest{Username: "a"}, &exportStream{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// watchHealth reports LocationService as serving while the database answers
+// pings, checking every interval until ctx is cancelled. The overall ("")
+// status follows it.
+func watchHealth(ctx context.Context, hs *health.Server, ping func(context.Context) error, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last healthpb.HealthCheckResponse_ServingStatus
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := ping(pingCtx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if status != last {
+			if err != nil {
+				slog.Warn("LocationService is not serving", "error", err)
+			}
+			hs.SetServingStatus(pb.LocationService_ServiceDesc.ServiceName, status)
+			hs.SetServingStatus("", status)
+			last = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatus(t *testing.T, hs *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	return resp.Status
+}
+
+func TestWatchHealth(t *testing.T) {
+	hs := health.NewServer()
+	var dbDown atomic.Bool
+	ping := func(ctx context.Context) error {
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchHealth(ctx, hs, ping, 10*time.Millisecond)
+		close(done)
+	}()
+
+	service := pb.LocationService_ServiceDesc.ServiceName
+	assert.Eventually(t, func() bool {
+		return servingStatus(t, hs, service) == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 5*time.Millisecond)
+
+	dbDown.Store(true)
+	assert.Eventually(t, func() bool {
+		return servingStatus(t, hs, service) == healthpb.HealthCheckResponse_NOT_SERVING &&
+			servingStatus(t, hs, "") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
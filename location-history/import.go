@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ImportLocations inserts a batch of past locations in one statement. A
+// point is a duplicate when the user already has a location at the same
+// second, whether stored earlier, by a concurrent import or earlier in the
+// batch, so sending a batch twice inserts nothing the second time.
+func (s *server) ImportLocations(ctx context.Context, req *pb.ImportLocationsRequest) (*pb.ImportLocationsResponse, error) {
+	const columns = 6
+	values := make([]string, 0, len(req.GetLocations()))
+	args := make([]interface{}, 0, 1+columns*len(req.GetLocations()))
+	args = append(args, req.GetUsername())
+	for i, l := range req.GetLocations() {
+		n := 2 + i*columns
+		values = append(values, fmt.Sprintf("($%d::double precision, $%d::double precision, $%d::timestamp, $%d::double precision, $%d::double precision, $%d::double precision)",
+			n, n+1, n+2, n+3, n+4, n+5))
+		args = append(args, l.GetLatitude(), l.GetLongitude(), time.Unix(l.GetTimestamp(), 0), l.Accuracy, l.Altitude, l.Speed)
+	}
+
+	res, err := db.DB.ExecContext(ctx, `
+        INSERT INTO user_locations (username, latitude, longitude, timestamp, accuracy, altitude, speed)
+        SELECT $1, p.latitude, p.longitude, p.timestamp, p.accuracy, p.altitude, p.speed
+        FROM (VALUES `+strings.Join(values, ", ")+`) AS p(latitude, longitude, timestamp, accuracy, altitude, speed)
+        ON CONFLICT (username, timestamp) DO NOTHING`,
+		args...)
+	if err != nil {
+		locationsStored.WithLabelValues("failed").Add(float64(len(req.GetLocations())))
+		return nil, status.Errorf(codes.Internal, "failed to import locations: %v", err)
+	}
+
+	inserted, _ := res.RowsAffected()
+	locationsStored.WithLabelValues("stored").Add(float64(inserted))
+	locationsStored.WithLabelValues("duplicate").Add(float64(int64(len(req.GetLocations())) - inserted))
+	return &pb.ImportLocationsResponse{
+		Inserted:   inserted,
+		Duplicates: int64(len(req.GetLocations())) - inserted,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportLocations(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+	db.DB = testDB
+	assert.NoError(t, ensureLocationColumns())
+	assert.NoError(t, ensureLocationIndex())
+	_, err := testDB.Exec("DELETE FROM user_locations WHERE username = $1", "importuser")
+	assert.NoError(t, err)
+
+	s := &server{db: testDB}
+	start := time.Now().Add(-time.Hour).Unix()
+	req := &pb.ImportLocationsRequest{
+		Username: "importuser",
+		Locations: []*pb.ImportedLocation{
+			{Latitude: 1, Longitude: 1, Timestamp: start},
+			{Latitude: 2, Longitude: 2, Timestamp: start + 60},
+			{Latitude: 2, Longitude: 2, Timestamp: start + 60},
+		},
+	}
+
+	resp, err := s.ImportLocations(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetInserted())
+	assert.Equal(t, int64(1), resp.GetDuplicates())
+
+	// Sending the batch again inserts nothing
+	resp, err = s.ImportLocations(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), resp.GetInserted())
+	assert.Equal(t, int64(3), resp.GetDuplicates())
+
+	var count int
+	assert.NoError(t, testDB.QueryRow("SELECT COUNT(*) FROM user_locations WHERE username = $1", "importuser").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestImportLocationsConcurrent(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+	db.DB = testDB
+	assert.NoError(t, ensureLocationColumns())
+	assert.NoError(t, ensureLocationIndex())
+	_, err := testDB.Exec("DELETE FROM user_locations WHERE username = $1", "importuser2")
+	assert.NoError(t, err)
+
+	// Two jobs importing overlapping files: 0-299 and 100-399
+	s := &server{db: testDB}
+	start := time.Now().Add(-24 * time.Hour).Unix()
+	batch := func(from, to int64) *pb.ImportLocationsRequest {
+		req := &pb.ImportLocationsRequest{Username: "importuser2"}
+		for i := from; i < to; i++ {
+			req.Locations = append(req.Locations, &pb.ImportedLocation{Latitude: 1, Longitude: 1, Timestamp: start + i})
+		}
+		return req
+	}
+
+	var wg sync.WaitGroup
+	var inserted atomic.Int64
+	for _, req := range []*pb.ImportLocationsRequest{batch(0, 300), batch(100, 400)} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.ImportLocations(context.Background(), req)
+			assert.NoError(t, err)
+			inserted.Add(resp.GetInserted())
+		}()
+	}
+	wg.Wait()
+
+	var count int64
+	assert.NoError(t, testDB.QueryRow("SELECT COUNT(*) FROM user_locations WHERE username = $1", "importuser2").Scan(&count))
+	assert.Equal(t, int64(400), count)
+	assert.Equal(t, int64(400), inserted.Load())
+}
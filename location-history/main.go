@@ -3,38 +3,127 @@ package main
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"math"
 	"net"
-	"strconv"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/abotoiGrid/Golang-Project/config"
 	"github.com/abotoiGrid/Golang-Project/db"
 	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/telemetry"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
 type server struct {
 	pb.UnimplementedLocationServiceServer
-	db *sql.DB
+	db        *sql.DB
+	retention *retentionJob
 }
 
 func (s *server) UpdateLocation(ctx context.Context, req *pb.LocationRequest) (*pb.LocationResponse, error) {
-	timestamp, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	timestampTime := time.Unix(req.UnixTimestamp(), 0)
+
+	// Positions are kept per second; a second update within the same
+	// second is dropped.
+	var id int64
+	err := db.DB.QueryRowContext(ctx, `
+        INSERT INTO user_locations (username, latitude, longitude, timestamp, accuracy, altitude, speed)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (username, timestamp) DO NOTHING
+        RETURNING id`,
+		req.GetUsername(), req.GetLatitude(), req.GetLongitude(), timestampTime, req.Accuracy, req.Altitude, req.Speed).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		locationsStored.WithLabelValues("duplicate").Inc()
+		return &pb.LocationResponse{Status: "Success", Duplicate: true}, nil
+	}
+	if err != nil {
+		locationsStored.WithLabelValues("failed").Inc()
+		return &pb.LocationResponse{Status: "Failed"}, err
+	}
+
+	locationsStored.WithLabelValues("stored").Inc()
+	return &pb.LocationResponse{Status: "Success", Id: id}, nil
+}
+
+// ensureLocationColumns adds the row ID and the optional measurements
+// devices may report to user_locations. The measurements stay NULL when a
+// device does not send them.
+func ensureLocationColumns() error {
+	_, err := db.DB.Exec(`
+        ALTER TABLE user_locations
+            ADD COLUMN IF NOT EXISTS id BIGSERIAL,
+            ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION,
+            ADD COLUMN IF NOT EXISTS altitude DOUBLE PRECISION,
+            ADD COLUMN IF NOT EXISTS speed DOUBLE PRECISION`)
+	return err
+}
+
+// errLocationDuplicates stops startup until duplicates stored before the
+// unique index existed have been removed with -dedupe-locations.
+var errLocationDuplicates = errors.New("user_locations has several positions of a user in the same second; run location-history -dedupe-locations once to keep one of each")
+
+// ensureLocationIndex makes a user's positions unique per second, which
+// UpdateLocation and ImportLocations rely on to skip duplicates.
+func ensureLocationIndex() error {
+	var indexed bool
+	err := db.DB.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM pg_indexes
+            WHERE tablename = 'user_locations' AND indexname = 'user_locations_username_timestamp_key')`).Scan(&indexed)
+	if err != nil || indexed {
+		return err
+	}
+	var duplicates bool
+	err = db.DB.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM user_locations
+            GROUP BY username, timestamp
+            HAVING COUNT(*) > 1)`).Scan(&duplicates)
 	if err != nil {
-		return &pb.LocationResponse{Status: "Failed"}, fmt.Errorf("failed to parse timestamp: %v", err)
+		return err
 	}
-	timestampTime := time.Unix(timestamp, 0)
+	if duplicates {
+		return errLocationDuplicates
+	}
+	_, err = db.DB.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS user_locations_username_timestamp_key
+        ON user_locations (username, timestamp)`)
+	return err
+}
 
-	_, err = db.DB.Exec("INSERT INTO user_locations (username, latitude, longitude, timestamp) VALUES ($1, $2, $3, $4)",
-		req.Username, req.Latitude, req.Longitude, timestampTime)
+// dedupeLocations keeps one row of each user and second, the oldest, and
+// returns how many rows it deleted. It is the one-off migration run by
+// -dedupe-locations before the unique index can be created.
+func dedupeLocations(ctx context.Context) (int64, error) {
+	res, err := db.DB.ExecContext(ctx, `
+        DELETE FROM user_locations a
+        USING user_locations b
+        WHERE a.username = b.username AND a.timestamp = b.timestamp AND a.id > b.id`)
 	if err != nil {
-		return &pb.LocationResponse{Status: "Failed"}, err
+		return 0, err
 	}
+	return res.RowsAffected()
+}
 
-	return &pb.LocationResponse{Status: "Success"}, nil
+func (s *server) GetRetentionStatus(ctx context.Context, req *pb.RetentionStatusRequest) (*pb.RetentionStatusResponse, error) {
+	return s.retention.status(), nil
 }
 
 func CalculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
@@ -53,23 +142,167 @@ func CalculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 }
 
 func main() {
-	db.InitDB()
-	defer db.DB.Close()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	dedupe := flag.Bool("dedupe-locations", false, "delete positions stored twice in the same second from user_locations, then exit")
+	flag.Parse()
 
-	go func() {
-		lis, err := net.Listen("tcp", ":50051")
+	cfg, err := config.LoadHistory(*configPath)
+	if err != nil {
+		telemetry.Fatal("Failed to load configuration", err)
+	}
+	if err := telemetry.InitLogging("location-history", cfg.Logging); err != nil {
+		telemetry.Fatal("Failed to configure logging", err)
+	}
+	slog.Info("Effective configuration", "config", config.Redacted(cfg))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := telemetry.InitTracing(ctx, "location-history", cfg.Tracing)
+	if err != nil {
+		telemetry.Fatal("Failed to configure tracing", err)
+	}
+
+	if err := db.InitDB(cfg.Database); err != nil {
+		telemetry.Fatal("Failed to connect to database", err)
+	}
+	if *dedupe {
+		if err := ensureLocationColumns(); err != nil {
+			telemetry.Fatal("Failed to add location columns", err)
+		}
+		n, err := dedupeLocations(ctx)
 		if err != nil {
-			log.Fatalf("Failed to listen: %v", err)
+			telemetry.Fatal("Failed to remove duplicate locations", err)
 		}
-		s := grpc.NewServer()
-		pb.RegisterLocationServiceServer(s, &server{})
-		reflection.Register(s)
-		log.Println("LocationHistory gRPC server started on :50051")
+		slog.Info("Removed duplicate locations", "rows", n)
+		return
+	}
+
+	opts, err := serverOptions(cfg.TLS)
+	if err != nil {
+		telemetry.Fatal("Failed to configure TLS", err)
+	}
+	// Installed first so calls rejected by the caller policy are traced and
+	// counted too.
+	opts = append([]grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.ChainUnaryInterceptor(telemetry.UnaryServerInterceptor, metricsUnaryInterceptor),
+		grpc.ChainStreamInterceptor(telemetry.StreamServerInterceptor, metricsStreamInterceptor),
+	}, opts...)
+	// Validation runs last so unauthorized callers learn nothing about
+	// which fields they got wrong.
+	opts = append(opts, grpc.ChainUnaryInterceptor(validation.UnaryServerInterceptor))
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.DB, "locations"))
+
+	retention := newRetentionJob(cfg.Retention)
+	if err := ensureRetentionTables(); err != nil {
+		telemetry.Fatal("Failed to create retention tables", err)
+	}
+	if err := ensureLocationColumns(); err != nil {
+		telemetry.Fatal("Failed to add location columns", err)
+	}
+	if err := ensureLocationIndex(); err != nil {
+		telemetry.Fatal("Failed to index user_locations", err)
+	}
+	if err := ensureDevicesTable(); err != nil {
+		telemetry.Fatal("Failed to create devices table", err)
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus(pb.LocationService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	var jobs sync.WaitGroup
+	jobs.Add(2)
+	go func() {
+		defer jobs.Done()
+		retention.run(ctx)
+	}()
+	go func() {
+		defer jobs.Done()
+		watchHealth(ctx, hs, db.DB.PingContext, healthCheckInterval)
+	}()
+
+	lis, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		telemetry.Fatal("Failed to listen", err)
+	}
+	srv := &server{retention: retention}
+	s := grpc.NewServer(opts...)
+	pb.RegisterLocationServiceServer(s, srv)
+	healthpb.RegisterHealthServer(s, hs)
+	reflection.Register(s)
+	go func() {
+		slog.Info("LocationHistory gRPC server started", "addr", cfg.ListenAddr)
 		if err := s.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve: %v", err)
+			telemetry.Fatal("Failed to serve", err)
 		}
 	}()
 
-	select {}
+	var nmea *nmeaListener
+	if cfg.NMEA.ListenAddr != "" {
+		nmeaLis, err := nmeaListen(cfg.NMEA.ListenAddr, cfg.TLS)
+		if err != nil {
+			telemetry.Fatal("Failed to listen for NMEA", err)
+		}
+		nmea = newNMEAListener(cfg.NMEA, srv)
+		go func() {
+			slog.Info("NMEA listener started", "addr", cfg.NMEA.ListenAddr)
+			if err := nmea.serve(nmeaLis); err != nil {
+				telemetry.Fatal("Failed to serve NMEA", err)
+			}
+		}()
+	}
 
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+	go func() {
+		slog.Info("Metrics server started", "addr", cfg.MetricsAddr)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			telemetry.Fatal("Failed to serve metrics", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down LocationHistory")
+
+	// Report NOT_SERVING first so clients stop routing calls here while the
+	// in-flight ones drain.
+	hs.Shutdown()
+	if nmea != nil {
+		nmea.shutdown()
+	}
+	gracefulStop(s, time.Duration(cfg.ShutdownTimeout))
+	jobs.Wait()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		metricsServer.Close()
+	}
+	if err := db.DB.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("LocationHistory stopped")
+}
+
+// gracefulStop lets in-flight calls finish and forcibly closes whatever is
+// still open after timeout.
+func gracefulStop(s *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("gRPC server did not drain in time, closing remaining connections")
+		s.Stop()
+		<-done
+	}
 }
@@ -5,8 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"os"
-	"strconv"
 	"testing"
 	"time"
 
@@ -14,6 +14,9 @@ import (
 	pb "github.com/abotoiGrid/Golang-Project/proto"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
 )
 
 var testDB *sql.DB
@@ -56,17 +59,29 @@ func TestUpdateLocation(t *testing.T) {
 	setupTestDB()
 	defer teardownTestDB()
 	db.DB = testDB
+	assert.NoError(t, ensureLocationColumns())
+	assert.NoError(t, ensureLocationIndex())
 
 	s := &server{db: testDB}
 
-	_, err := s.UpdateLocation(context.Background(), &pb.LocationRequest{
-		Username:  "testuser8",
-		Latitude:  37.7749,
-		Longitude: -122.4194,
-		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
-	})
+	req := &pb.LocationRequest{
+		Username:      "testuser8",
+		Latitude:      proto.Float64(37.7749),
+		Longitude:     proto.Float64(-122.4194),
+		TimestampUnix: time.Now().Unix(),
+		Accuracy:      proto.Float64(12),
+	}
+	resp, err := s.UpdateLocation(context.Background(), req)
+
+	assert.NoError(t, err)
+	assert.NotZero(t, resp.GetId())
+	assert.False(t, resp.GetDuplicate())
 
+	// A second update within the same second keeps the first
+	resp, err = s.UpdateLocation(context.Background(), req)
 	assert.NoError(t, err)
+	assert.Zero(t, resp.GetId())
+	assert.True(t, resp.GetDuplicate())
 
 	var count int
 	err = testDB.QueryRow("SELECT COUNT(*) FROM user_locations WHERE username = $1", "testuser8").Scan(&count)
@@ -74,3 +89,64 @@ func TestUpdateLocation(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 }
+
+func TestDedupeLocations(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+	db.DB = testDB
+	assert.NoError(t, ensureLocationColumns())
+	_, err := testDB.Exec("DROP INDEX IF EXISTS user_locations_username_timestamp_key")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, ensureLocationIndex()) }()
+
+	_, err = testDB.Exec("DELETE FROM user_locations WHERE username = $1", "dupeuser")
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = testDB.Exec("INSERT INTO user_locations (username, latitude, longitude, timestamp) VALUES ($1, $2, 1, '2023-01-01T00:00:00Z')",
+			"dupeuser", float64(i))
+		assert.NoError(t, err)
+	}
+
+	// Startup refuses to delete rows on its own
+	assert.ErrorIs(t, ensureLocationIndex(), errLocationDuplicates)
+
+	n, err := dedupeLocations(context.Background())
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, int64(2))
+	var latitude float64
+	assert.NoError(t, testDB.QueryRow("SELECT latitude FROM user_locations WHERE username = $1", "dupeuser").Scan(&latitude))
+	assert.Equal(t, 0.0, latitude)
+	assert.NoError(t, ensureLocationIndex())
+}
+
+type blockingServer struct {
+	pb.UnimplementedLocationServiceServer
+	started chan struct{}
+}
+
+func (s *blockingServer) UpdateLocation(ctx context.Context, req *pb.LocationRequest) (*pb.LocationResponse, error) {
+	close(s.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGracefulStopTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := grpc.NewServer()
+	blocking := &blockingServer{started: make(chan struct{})}
+	pb.RegisterLocationServiceServer(s, blocking)
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	go pb.NewLocationServiceClient(conn).UpdateLocation(context.Background(), &pb.LocationRequest{Username: "testuser"})
+	<-blocking.started
+
+	// The in-flight call never finishes on its own, so the server is
+	// stopped once the timeout expires.
+	start := time.Now()
+	gracefulStop(s, 100*time.Millisecond)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
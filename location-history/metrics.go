@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_history_grpc_requests_total",
+		Help: "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "location_history_grpc_request_duration_seconds",
+		Help:    "gRPC call latency by method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	locationsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_history_locations_stored_total",
+		Help: "Location points received by result: stored, duplicate (already stored for that second) or failed.",
+	}, []string{"result"})
+
+	retentionRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "location_history_retention_removed_points_total",
+		Help: "Location points removed by the retention job.",
+	})
+
+	nmeaSentences = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_history_nmea_sentences_total",
+		Help: "NMEA sentences received by result: stored, ignored, invalid or failed.",
+	}, []string{"result"})
+)
+
+func observeCall(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeCall(info.FullMethod, start, err)
+	return resp, err
+}
+
+func metricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeCall(info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsUnaryInterceptor(t *testing.T) {
+	const method = "/location.LocationService/UpdateLocation"
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	ok := grpcRequests.WithLabelValues(method, "OK")
+	denied := grpcRequests.WithLabelValues(method, "PermissionDenied")
+	okBefore, deniedBefore := testutil.ToFloat64(ok), testutil.ToFloat64(denied)
+
+	_, err := metricsUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "done", nil
+	})
+	assert.NoError(t, err)
+
+	_, err = metricsUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	assert.Equal(t, okBefore+1, testutil.ToFloat64(ok))
+	assert.Equal(t, deniedBefore+1, testutil.ToFloat64(denied))
+
+	// Errors without a gRPC status are reported as Unknown.
+	_, _ = metricsUnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	assert.Equal(t, 1, testutil.CollectAndCount(grpcRequests.WithLabelValues(method, "Unknown")))
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// nmeaMaxLine leaves room above the 82 characters NMEA 0183 allows.
+	nmeaMaxLine      = 256
+	nmeaStoreTimeout = 5 * time.Second
+
+	// nmeaHandshake is the address of the sentence identifying a unit.
+	nmeaHandshake = "PDEV"
+
+	nmeaStored  = "stored"
+	nmeaIgnored = "ignored"
+	nmeaInvalid = "invalid"
+	nmeaFailed  = "failed"
+)
+
+var errNMEAChecksum = errors.New("checksum mismatch")
+
+// parseNMEA checks the checksum of an NMEA 0183 sentence and returns its
+// comma-separated fields, the first being the address such as GPRMC.
+// Sentences without a checksum are rejected.
+func parseNMEA(line string) ([]string, error) {
+	if !strings.HasPrefix(line, "$") {
+		return nil, errors.New("missing $")
+	}
+	body, sum, ok := strings.Cut(line[1:], "*")
+	if !ok || len(sum) != 2 {
+		return nil, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil {
+		return nil, errNMEAChecksum
+	}
+	var got byte
+	for i := 0; i < len(body); i++ {
+		got ^= body[i]
+	}
+	if got != byte(want) {
+		return nil, errNMEAChecksum
+	}
+	return strings.Split(body, ","), nil
+}
+
+// nmeaCoordinate converts ddmm.mmmm (or dddmm.mmmm) and a hemisphere to
+// signed degrees.
+func nmeaCoordinate(value, hemisphere, positive, negative string) (float64, error) {
+	dot := strings.IndexByte(value, '.')
+	if dot < 0 {
+		dot = len(value)
+	}
+	if dot < 3 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	degrees, err := strconv.ParseUint(value[:dot-2], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	minutes, err := strconv.ParseFloat(value[dot-2:], 64)
+	if err != nil || minutes >= 60 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	deg := float64(degrees) + minutes/60
+	switch hemisphere {
+	case negative:
+		return -deg, nil
+	case positive:
+		return deg, nil
+	}
+	return 0, fmt.Errorf("invalid hemisphere %q", hemisphere)
+}
+
+// nmeaClock parses hhmmss(.sss) as a duration since midnight. Fractions of
+// a second are dropped since positions are stored with second precision.
+func nmeaClock(value string) (time.Duration, error) {
+	if len(value) < 6 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	h, err1 := strconv.Atoi(value[0:2])
+	m, err2 := strconv.Atoi(value[2:4])
+	s, err3 := strconv.Atoi(value[4:6])
+	if err1 != nil || err2 != nil || err3 != nil || h > 23 || m > 59 || s > 60 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second, nil
+}
+
+// nmeaDecoder turns the sentences of one connection into fixes. GGA carries
+// no date, so it takes the date of the last RMC, or the current UTC date
+// until an RMC arrives.
+type nmeaDecoder struct {
+	now  func() time.Time
+	date time.Time
+}
+
+// decode returns the fix in fields. ok is false for sentences that carry
+// no fix, such as other sentence types or receivers without a lock.
+func (d *nmeaDecoder) decode(fields []string) (lat, lon float64, at time.Time, ok bool, err error) {
+	if len(fields[0]) != 5 {
+		return 0, 0, time.Time{}, false, nil
+	}
+	// Any talker: GP, GN, GL, ...
+	switch fields[0][2:] {
+	case "RMC":
+		if len(fields) < 10 {
+			return 0, 0, time.Time{}, false, errors.New("short RMC sentence")
+		}
+		if fields[2] != "A" {
+			return 0, 0, time.Time{}, false, nil
+		}
+		date, err := time.Parse("020106", fields[9])
+		if err != nil {
+			return 0, 0, time.Time{}, false, fmt.Errorf("invalid date %q", fields[9])
+		}
+		clock, err := nmeaClock(fields[1])
+		if err != nil {
+			return 0, 0, time.Time{}, false, err
+		}
+		d.date = date
+		at = date.Add(clock)
+		lat, lon, err = nmeaPosition(fields[3:7])
+		return lat, lon, at, err == nil, err
+	case "GGA":
+		if len(fields) < 7 {
+			return 0, 0, time.Time{}, false, errors.New("short GGA sentence")
+		}
+		if fields[6] == "" || fields[6] == "0" {
+			return 0, 0, time.Time{}, false, nil
+		}
+		clock, err := nmeaClock(fields[1])
+		if err != nil {
+			return 0, 0, time.Time{}, false, err
+		}
+		if d.date.IsZero() {
+			now := d.now().UTC()
+			at = now.Truncate(24 * time.Hour).Add(clock)
+			// A fix from just before midnight arriving just after it.
+			if at.After(now.Add(time.Hour)) {
+				at = at.AddDate(0, 0, -1)
+			}
+		} else {
+			at = d.date.Add(clock)
+		}
+		lat, lon, err = nmeaPosition(fields[2:6])
+		return lat, lon, at, err == nil, err
+	}
+	return 0, 0, time.Time{}, false, nil
+}
+
+// nmeaPosition parses latitude, N/S, longitude, E/W.
+func nmeaPosition(fields []string) (lat, lon float64, err error) {
+	if lat, err = nmeaCoordinate(fields[0], fields[1], "N", "S"); err != nil {
+		return 0, 0, err
+	}
+	if lon, err = nmeaCoordinate(fields[2], fields[3], "E", "W"); err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// nmeaListener accepts TCP connections from GPS units sending raw NMEA 0183.
+// A unit first identifies itself with a proprietary $PDEV,<device_id>*hh
+// sentence; the device must be registered, and its fixes from RMC and GGA
+// sentences are stored for the owning user.
+type nmeaListener struct {
+	idleTimeout time.Duration
+	lookup      func(ctx context.Context, deviceID string) (string, error)
+	store       func(ctx context.Context, req *pb.LocationRequest) error
+	now         func() time.Time
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// newNMEAListener stores fixes through srv.UpdateLocation, after the same
+// validation gRPC requests get.
+func newNMEAListener(cfg config.NMEA, srv *server) *nmeaListener {
+	return &nmeaListener{
+		idleTimeout: time.Duration(cfg.IdleTimeout),
+		lookup:      deviceUsername,
+		store: func(ctx context.Context, req *pb.LocationRequest) error {
+			if err := req.Validate(); err != nil {
+				return err
+			}
+			_, err := srv.UpdateLocation(ctx, req)
+			return err
+		},
+		now:   time.Now,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// serve accepts connections on lis until shutdown is called.
+func (l *nmeaListener) serve(lis net.Listener) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		lis.Close()
+		return net.ErrClosed
+	}
+	l.listener = lis
+	l.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !l.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer l.wg.Done()
+			defer l.untrack(conn)
+			l.handle(conn)
+		}()
+	}
+}
+
+func (l *nmeaListener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.wg.Add(1)
+	return true
+}
+
+func (l *nmeaListener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	conn.Close()
+}
+
+// shutdown stops accepting, closes open connections and waits for their
+// handlers to return.
+func (l *nmeaListener) shutdown() {
+	l.mu.Lock()
+	l.closed = true
+	if l.listener != nil {
+		l.listener.Close()
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
+
+// handle reads sentences from conn until it is closed, idles for too long
+// or sends something other than NMEA.
+func (l *nmeaListener) handle(conn net.Conn) {
+	log := slog.With("remote_addr", conn.RemoteAddr().String())
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, nmeaMaxLine), nmeaMaxLine)
+	next := func() (string, bool) {
+		conn.SetReadDeadline(time.Now().Add(l.idleTimeout))
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	line, ok := next()
+	if !ok {
+		return
+	}
+	fields, err := parseNMEA(line)
+	if err != nil || fields[0] != nmeaHandshake || len(fields) != 2 {
+		nmeaSentences.WithLabelValues(nmeaInvalid).Inc()
+		log.Warn("NMEA connection did not identify its device")
+		return
+	}
+	deviceID := fields[1]
+	ctx, cancel := context.WithTimeout(context.Background(), nmeaStoreTimeout)
+	username, err := l.lookup(ctx, deviceID)
+	cancel()
+	if errors.Is(err, errUnknownDevice) {
+		log.Warn("NMEA connection from unknown device", "device_id", deviceID)
+		return
+	}
+	if err != nil {
+		log.Error("Failed to look up NMEA device", "device_id", deviceID, "error", err)
+		return
+	}
+	log = log.With("device_id", deviceID, "username", username)
+	log.Info("NMEA device connected")
+
+	d := &nmeaDecoder{now: l.now}
+	var last int64
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		fields, err := parseNMEA(line)
+		if err != nil {
+			nmeaSentences.WithLabelValues(nmeaInvalid).Inc()
+			log.Debug("Invalid NMEA sentence", "error", err)
+			continue
+		}
+		lat, lon, at, ok, err := d.decode(fields)
+		if err != nil {
+			nmeaSentences.WithLabelValues(nmeaInvalid).Inc()
+			log.Debug("Invalid NMEA sentence", "error", err)
+			continue
+		}
+		// RMC and GGA usually report the same fix; keep one per second.
+		if !ok || at.Unix() == last {
+			nmeaSentences.WithLabelValues(nmeaIgnored).Inc()
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), nmeaStoreTimeout)
+		err = l.store(ctx, &pb.LocationRequest{
+			Username:      username,
+			Latitude:      proto.Float64(lat),
+			Longitude:     proto.Float64(lon),
+			TimestampUnix: at.Unix(),
+		})
+		cancel()
+		if err != nil {
+			nmeaSentences.WithLabelValues(nmeaFailed).Inc()
+			log.Warn("Failed to store NMEA fix", "error", err)
+			continue
+		}
+		nmeaSentences.WithLabelValues(nmeaStored).Inc()
+		last = at.Unix()
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Info("NMEA device disconnected", "error", err)
+		return
+	}
+	log.Info("NMEA device disconnected")
+}
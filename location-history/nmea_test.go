@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRMC = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+	testGGA = "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47"
+)
+
+// nmeaSentence appends the checksum to body.
+func nmeaSentence(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, sum)
+}
+
+func TestParseNMEA(t *testing.T) {
+	fields, err := parseNMEA(testRMC)
+	assert.NoError(t, err)
+	assert.Equal(t, "GPRMC", fields[0])
+	assert.Len(t, fields, 12)
+
+	_, err = parseNMEA("$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6B")
+	assert.ErrorIs(t, err, errNMEAChecksum)
+	_, err = parseNMEA("$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W")
+	assert.ErrorContains(t, err, "missing checksum")
+	_, err = parseNMEA("GPRMC*6A")
+	assert.ErrorContains(t, err, "missing $")
+}
+
+func TestNMEADecoder(t *testing.T) {
+	now := time.Date(2024, 5, 2, 0, 30, 0, 0, time.UTC)
+	d := &nmeaDecoder{now: func() time.Time { return now }}
+	decode := func(line string) (float64, float64, time.Time, bool, error) {
+		fields, err := parseNMEA(line)
+		assert.NoError(t, err)
+		return d.decode(fields)
+	}
+
+	// Without an RMC yet, a GGA from before midnight belongs to yesterday
+	lat, lon, at, ok, err := decode(testGGA)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 48.1173, lat, 1e-4)
+	assert.InDelta(t, 11.516667, lon, 1e-4)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 35, 19, 0, time.UTC), at)
+
+	lat, lon, at, ok, err = decode(testRMC)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 48.1173, lat, 1e-4)
+	assert.InDelta(t, 11.516667, lon, 1e-4)
+	assert.Equal(t, time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC), at)
+
+	// GGA takes the date of the last RMC; other talkers work too
+	lat, lon, at, ok, err = decode(nmeaSentence("GNGGA,123520,3351.000,S,15112.000,W,1,08,0.9,10.0,M,0.0,M,,"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, -33.85, lat, 1e-9)
+	assert.InDelta(t, -151.2, lon, 1e-9)
+	assert.Equal(t, time.Date(1994, 3, 23, 12, 35, 20, 0, time.UTC), at)
+
+	// No fix
+	_, _, _, ok, err = decode(nmeaSentence("GPRMC,123521,V,,,,,,,230394,,"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, _, _, ok, err = decode(nmeaSentence("GPGGA,123521,,,,,0,00,,,M,,M,,"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, _, _, ok, err = decode(nmeaSentence("GPGSV,3,1,11,03,03,111,00"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, _, _, err = decode(nmeaSentence("GPRMC,123521,A,4807.038,X,01131.000,E,,,230394,,"))
+	assert.ErrorContains(t, err, "invalid hemisphere")
+	_, _, _, _, err = decode(nmeaSentence("GPRMC,123521,A,4860.000,N,01131.000,E,,,230394,,"))
+	assert.ErrorContains(t, err, "invalid coordinate")
+	_, _, _, _, err = decode(nmeaSentence("GPRMC,253521,A,4807.038,N,01131.000,E,,,230394,,"))
+	assert.ErrorContains(t, err, "invalid time")
+}
+
+func TestNMEAListener(t *testing.T) {
+	var mu sync.Mutex
+	var stored []*pb.LocationRequest
+	l := &nmeaListener{
+		idleTimeout: 5 * time.Second,
+		lookup: func(ctx context.Context, deviceID string) (string, error) {
+			if deviceID == "truck-1" {
+				return "testuser", nil
+			}
+			return "", errUnknownDevice
+		},
+		store: func(ctx context.Context, req *pb.LocationRequest) error {
+			mu.Lock()
+			defer mu.Unlock()
+			stored = append(stored, req)
+			return nil
+		},
+		now:   time.Now,
+		conns: make(map[net.Conn]struct{}),
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- l.serve(lis) }()
+
+	// Unknown devices are disconnected
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.NoError(t, err)
+	fmt.Fprintf(conn, "%s\r\n", nmeaSentence("PDEV,truck-2"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	conn.Close()
+
+	conn, err = net.Dial("tcp", lis.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\r\n", nmeaSentence("PDEV,truck-1"))
+	fmt.Fprintf(conn, "%s\r\n", testRMC)
+	fmt.Fprintf(conn, "%s\r\n", testGGA)
+	fmt.Fprintf(conn, "%s\r\n", "$GPRMC,123520,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*00")
+	fmt.Fprintf(conn, "%s\r\n", nmeaSentence("GPRMC,123521,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W"))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(stored) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The GGA repeats the first fix and the third sentence has a bad checksum
+	mu.Lock()
+	assert.Equal(t, "testuser", stored[0].GetUsername())
+	assert.Equal(t, time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC).Unix(), stored[0].GetTimestampUnix())
+	assert.InDelta(t, 48.1173, stored[0].GetLatitude(), 1e-4)
+	assert.Equal(t, time.Date(1994, 3, 23, 12, 35, 21, 0, time.UTC).Unix(), stored[1].GetTimestampUnix())
+	mu.Unlock()
+
+	// Shutdown closes open connections
+	l.shutdown()
+	assert.NoError(t, <-served)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
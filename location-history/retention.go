@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retentionJob periodically removes location points older than the
+// retention period of their user, optionally rolling them up into daily
+// summaries first.
+type retentionJob struct {
+	defaultDays int
+	mode        string
+	batchSize   int
+	interval    time.Duration
+
+	mu   sync.Mutex
+	last *pb.RetentionStatusResponse
+}
+
+// newRetentionJob applies cfg.Days to every user without an entry in
+// retention_overrides.
+func newRetentionJob(cfg config.Retention) *retentionJob {
+	return &retentionJob{
+		defaultDays: cfg.Days,
+		mode:        cfg.Mode,
+		batchSize:   cfg.BatchSize,
+		interval:    time.Duration(cfg.Interval),
+	}
+}
+
+func ensureRetentionTables() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS retention_overrides (
+            username TEXT PRIMARY KEY,
+            retain_days INTEGER NOT NULL CHECK (retain_days >= 0)
+        );
+        CREATE TABLE IF NOT EXISTS user_location_daily (
+            username TEXT NOT NULL,
+            day DATE NOT NULL,
+            points INTEGER NOT NULL,
+            avg_latitude DOUBLE PRECISION NOT NULL,
+            avg_longitude DOUBLE PRECISION NOT NULL,
+            first_seen TIMESTAMP NOT NULL,
+            last_seen TIMESTAMP NOT NULL,
+            PRIMARY KEY (username, day)
+        )`)
+	return err
+}
+
+// run purges expired points every interval until ctx is cancelled.
+func (j *retentionJob) run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *retentionJob) runOnce(ctx context.Context) {
+	start := time.Now()
+	status := &pb.RetentionStatusResponse{
+		StartedAt: start.Unix(),
+		Mode:      j.mode,
+	}
+	removed := make(map[string]int64)
+
+	for ctx.Err() == nil {
+		n, days, err := j.purgeBatch(ctx, removed)
+		status.RemovedPoints += n
+		retentionRemoved.Add(float64(n))
+		status.AggregatedDays += days
+		if err != nil {
+			status.Error = err.Error()
+			slog.ErrorContext(ctx, "Retention purge failed", "error", err)
+			break
+		}
+		if n < int64(j.batchSize) {
+			break
+		}
+	}
+
+	status.FinishedAt = time.Now().Unix()
+	for username, n := range removed {
+		slog.InfoContext(ctx, "Retention removed points", "username", username, "points", n)
+	}
+	slog.InfoContext(ctx, "Retention run finished",
+		"removed_points", status.RemovedPoints,
+		"aggregated_days", status.AggregatedDays,
+		"latency_ms", time.Since(start).Milliseconds())
+
+	j.mu.Lock()
+	j.last = status
+	j.mu.Unlock()
+}
+
+// purgeBatch removes at most batchSize expired points in one transaction
+// and returns how many points were removed and daily rows were written.
+func (j *retentionJob) purgeBatch(ctx context.Context, removed map[string]int64) (int64, int64, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        DELETE FROM user_locations
+        WHERE id IN (
+            SELECT l.id
+            FROM user_locations l
+            LEFT JOIN retention_overrides o ON o.username = l.username
+            WHERE COALESCE(o.retain_days, $1) > 0
+              AND l.timestamp < NOW() - make_interval(days => COALESCE(o.retain_days, $1))
+            LIMIT $2)
+        RETURNING username, latitude, longitude, timestamp`,
+		j.defaultDays, j.batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var points []expiredPoint
+	for rows.Next() {
+		var p expiredPoint
+		if err := rows.Scan(&p.username, &p.latitude, &p.longitude, &p.timestamp); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		points = append(points, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	var days int64
+	if j.mode == config.RetentionAggregate {
+		for _, d := range aggregateDaily(points) {
+			if err := upsertDaily(ctx, tx, d); err != nil {
+				return 0, 0, err
+			}
+			days++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	for _, p := range points {
+		removed[p.username]++
+	}
+	return int64(len(points)), days, nil
+}
+
+type expiredPoint struct {
+	username  string
+	latitude  float64
+	longitude float64
+	timestamp time.Time
+}
+
+type dailySummary struct {
+	username     string
+	day          time.Time
+	points       int
+	avgLatitude  float64
+	avgLongitude float64
+	firstSeen    time.Time
+	lastSeen     time.Time
+}
+
+// aggregateDaily groups points by user and UTC day.
+func aggregateDaily(points []expiredPoint) []*dailySummary {
+	type key struct {
+		username string
+		day      time.Time
+	}
+	byDay := make(map[key]*dailySummary)
+	var order []*dailySummary
+
+	for _, p := range points {
+		ts := p.timestamp.UTC()
+		k := key{p.username, time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)}
+		d, ok := byDay[k]
+		if !ok {
+			d = &dailySummary{username: k.username, day: k.day, firstSeen: p.timestamp, lastSeen: p.timestamp}
+			byDay[k] = d
+			order = append(order, d)
+		}
+		n := float64(d.points)
+		d.avgLatitude = (d.avgLatitude*n + p.latitude) / (n + 1)
+		d.avgLongitude = (d.avgLongitude*n + p.longitude) / (n + 1)
+		d.points++
+		if p.timestamp.Before(d.firstSeen) {
+			d.firstSeen = p.timestamp
+		}
+		if p.timestamp.After(d.lastSeen) {
+			d.lastSeen = p.timestamp
+		}
+	}
+	return order
+}
+
+func upsertDaily(ctx context.Context, tx *sql.Tx, d *dailySummary) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO user_location_daily AS d
+            (username, day, points, avg_latitude, avg_longitude, first_seen, last_seen)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (username, day) DO UPDATE SET
+            avg_latitude = (d.avg_latitude * d.points + EXCLUDED.avg_latitude * EXCLUDED.points) / (d.points + EXCLUDED.points),
+            avg_longitude = (d.avg_longitude * d.points + EXCLUDED.avg_longitude * EXCLUDED.points) / (d.points + EXCLUDED.points),
+            points = d.points + EXCLUDED.points,
+            first_seen = LEAST(d.first_seen, EXCLUDED.first_seen),
+            last_seen = GREATEST(d.last_seen, EXCLUDED.last_seen)`,
+		d.username, d.day, d.points, d.avgLatitude, d.avgLongitude, d.firstSeen, d.lastSeen)
+	return err
+}
+
+// SetRetentionOverride keeps the user's points for req.RetainDays instead of
+// the default, from the next run of the job.
+func (s *server) SetRetentionOverride(ctx context.Context, req *pb.RetentionOverride) (*pb.RetentionOverride, error) {
+	_, err := db.DB.ExecContext(ctx, `
+        INSERT INTO retention_overrides (username, retain_days) VALUES ($1, $2)
+        ON CONFLICT (username) DO UPDATE SET retain_days = EXCLUDED.retain_days`,
+		req.GetUsername(), req.GetRetainDays())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set retention override: %v", err)
+	}
+	return &pb.RetentionOverride{Username: req.GetUsername(), RetainDays: req.GetRetainDays()}, nil
+}
+
+// DeleteRetentionOverride returns the user to the default retention.
+func (s *server) DeleteRetentionOverride(ctx context.Context, req *pb.DeleteRetentionOverrideRequest) (*pb.DeleteRetentionOverrideResponse, error) {
+	res, err := db.DB.ExecContext(ctx, "DELETE FROM retention_overrides WHERE username = $1", req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete retention override: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Error(codes.NotFound, "retention override not found")
+	}
+	return &pb.DeleteRetentionOverrideResponse{}, nil
+}
+
+func (j *retentionJob) status() *pb.RetentionStatusResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.last == nil {
+		return &pb.RetentionStatusResponse{Mode: j.mode}
+	}
+	return j.last
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAggregateDaily(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	summaries := aggregateDaily([]expiredPoint{
+		{username: "testuser", latitude: 10, longitude: 20, timestamp: day1},
+		{username: "testuser", latitude: 12, longitude: 22, timestamp: day1.Add(2 * time.Hour)},
+		{username: "testuser", latitude: 30, longitude: 40, timestamp: day2},
+		{username: "otheruser", latitude: 1, longitude: 2, timestamp: day1},
+	})
+
+	assert.Len(t, summaries, 3)
+
+	first := summaries[0]
+	assert.Equal(t, "testuser", first.username)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), first.day)
+	assert.Equal(t, 2, first.points)
+	assert.InDelta(t, 11.0, first.avgLatitude, 1e-9)
+	assert.InDelta(t, 21.0, first.avgLongitude, 1e-9)
+	assert.Equal(t, day1, first.firstSeen)
+	assert.Equal(t, day1.Add(2*time.Hour), first.lastSeen)
+
+	assert.Equal(t, 1, summaries[1].points)
+	assert.Equal(t, "otheruser", summaries[2].username)
+}
+
+func TestNewRetentionJob(t *testing.T) {
+	cfg := config.DefaultHistory().Retention
+	cfg.Days = 30
+	cfg.Mode = config.RetentionAggregate
+	j := newRetentionJob(cfg)
+	assert.Equal(t, 30, j.defaultDays)
+	assert.Equal(t, time.Hour, j.interval)
+	assert.Equal(t, "aggregate", j.status().Mode)
+}
+
+func TestRetentionOverrides(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+	db.DB = testDB
+	assert.NoError(t, ensureRetentionTables())
+	_, err := testDB.Exec("DELETE FROM retention_overrides WHERE username = $1", "keepuser")
+	assert.NoError(t, err)
+
+	s := &server{db: testDB}
+	ctx := context.Background()
+	retainDays := func() int32 {
+		var days int32
+		assert.NoError(t, testDB.QueryRow("SELECT retain_days FROM retention_overrides WHERE username = $1", "keepuser").Scan(&days))
+		return days
+	}
+
+	resp, err := s.SetRetentionOverride(ctx, &pb.RetentionOverride{Username: "keepuser", RetainDays: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(30), resp.GetRetainDays())
+	assert.Equal(t, int32(30), retainDays())
+
+	// Setting it again replaces the override
+	_, err = s.SetRetentionOverride(ctx, &pb.RetentionOverride{Username: "keepuser", RetainDays: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), retainDays())
+
+	_, err = s.DeleteRetentionOverride(ctx, &pb.DeleteRetentionOverrideRequest{Username: "keepuser"})
+	assert.NoError(t, err)
+	_, err = s.DeleteRetentionOverride(ctx, &pb.DeleteRetentionOverrideRequest{Username: "keepuser"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
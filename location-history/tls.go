@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// callerPolicy decides which callers may use the gRPC server: an allowlist
+// of client certificate identities and/or a shared per-call token.
+type callerPolicy struct {
+	allowed map[string]bool
+	token   string
+}
+
+// serverOptions configures TLS from the server certificate and key. A
+// client CA additionally requires and verifies client certificates, the
+// allowed clients restrict them to a list of identities (CN, DNS or URI
+// SANs) and the call token is required as a bearer token on every call.
+func serverOptions(cfg config.ServerTLS) ([]grpc.ServerOption, error) {
+	policy := &callerPolicy{token: cfg.CallToken}
+	for _, id := range cfg.AllowedClients {
+		if policy.allowed == nil {
+			policy.allowed = make(map[string]bool)
+		}
+		policy.allowed[id] = true
+	}
+
+	tlsConfig, err := serverTLSConfig(cfg)
+	if tlsConfig == nil || err != nil {
+		return nil, err
+	}
+	if tlsConfig.ClientCAs != nil {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	opts := []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}
+	if policy.allowed != nil || policy.token != "" {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(policy.unaryInterceptor),
+			grpc.ChainStreamInterceptor(policy.streamInterceptor),
+		)
+	}
+	return opts, nil
+}
+
+// serverTLSConfig loads the server certificate and the client CA, if any.
+// It returns nil when TLS is not configured.
+func serverTLSConfig(cfg config.ServerTLS) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// nmeaListen listens for NMEA units on addr, with the server certificate
+// when TLS is configured. Many units cannot present a certificate, so client
+// certificates are verified against the client CA only when sent.
+func nmeaListen(addr string, cfg config.ServerTLS) (net.Listener, error) {
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil || tlsConfig == nil {
+		return lis, err
+	}
+	if tlsConfig.ClientCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tls.NewListener(lis, tlsConfig), nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// peerIdentities returns the identities of the verified client certificate.
+func peerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	ids := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+func (p *callerPolicy) check(ctx context.Context) error {
+	if p.allowed != nil {
+		permitted := false
+		for _, id := range peerIdentities(ctx) {
+			if p.allowed[id] {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return status.Error(codes.PermissionDenied, "client certificate is not allowed")
+		}
+	}
+
+	if p.token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return status.Error(codes.Unauthenticated, "missing call credentials")
+		}
+		token, _ := strings.CutPrefix(values[0], "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+			return status.Error(codes.Unauthenticated, "invalid call credentials")
+		}
+	}
+	return nil
+}
+
+// healthMethodPrefix identifies grpc.health.v1.Health calls, which probes
+// may make without call credentials or an allowed client certificate.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+func (p *callerPolicy) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+		return handler(ctx, req)
+	}
+	if err := p.check(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (p *callerPolicy) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+		return handler(srv, ss)
+	}
+	if err := p.check(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
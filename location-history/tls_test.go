@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerContext(cert *x509.Certificate) context.Context {
+	info := credentials.TLSInfo{State: tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+func TestCallerPolicyAllowlist(t *testing.T) {
+	p := &callerPolicy{allowed: map[string]bool{"location-management": true}}
+
+	ctx := peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "location-management"}})
+	assert.NoError(t, p.check(ctx))
+
+	ctx = peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"location-management"}})
+	assert.NoError(t, p.check(ctx))
+
+	ctx = peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(p.check(ctx)))
+
+	// No client certificate at all
+	assert.Equal(t, codes.PermissionDenied, status.Code(p.check(context.Background())))
+}
+
+func TestCallerPolicyToken(t *testing.T) {
+	p := &callerPolicy{token: "secret"}
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(p.check(context.Background())))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer wrong"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(p.check(ctx)))
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	assert.NoError(t, p.check(ctx))
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "location-history"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNMEAListenTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	// The client CA does not make client certificates mandatory
+	lis, err := nmeaListen("127.0.0.1:0", config.ServerTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile})
+	assert.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 5)
+				if n, err := conn.Read(buf); err == nil {
+					conn.Write(buf[:n])
+				}
+			}()
+		}
+	}()
+
+	certPEM, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{RootCAs: roots})
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("$PDEV"))
+	assert.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "$PDEV", string(buf))
+
+	// Plain TCP clients do not get through
+	plain, err := net.Dial("tcp", lis.Addr().String())
+	assert.NoError(t, err)
+	defer plain.Close()
+	plain.Write([]byte("$PDEV,truck-1*4C\r\n"))
+	plain.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _ := plain.Read(buf)
+	assert.NotEqual(t, "$PDEV", string(buf[:n]))
+
+	// Without a certificate the listener stays plain TCP
+	plainLis, err := nmeaListen("127.0.0.1:0", config.ServerTLS{})
+	assert.NoError(t, err)
+	defer plainLis.Close()
+	assert.IsType(t, &net.TCPListener{}, plainLis)
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// locationAPI is this service's LocationService implementation. It
+// authorizes the caller found in the request context, applies privacy and
+// visibility settings and forwards what location-history stores. The
+// grpc-gateway routes and the older unversioned gin routes both call it.
+type locationAPI struct {
+	pb.UnimplementedLocationServiceServer
+}
+
+var locationService = &locationAPI{}
+
+// newGateway serves the HTTP annotations of LocationService from
+// locationService. Field names stay snake_case like the rest of the API.
+func newGateway() http.Handler {
+	mux := runtime.NewServeMux(runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+		MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}))
+	// Registration only fails for a nil server.
+	_ = pb.RegisterLocationServiceHandlerServer(context.Background(), mux, locationService)
+	return mux
+}
+
+// historyError passes on failures the caller may retry and reports anything
+// else from location-history as an internal error with message.
+func historyError(err error, message string) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return err
+	}
+	return status.Error(codes.Internal, message)
+}
+
+// respondRPCError writes a locationAPI error in the shape the gin routes have
+// always used: {"error": message}, plus the invalid fields for validation
+// errors.
+func respondRPCError(c *gin.Context, err error) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		if errs, ok := validation.FromStatus(st); ok {
+			invalidRequest(c, errs)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": st.Message()})
+	case codes.FailedPrecondition:
+		c.JSON(http.StatusBadRequest, gin.H{"error": st.Message()})
+	case codes.Unauthenticated:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": st.Message()})
+	case codes.PermissionDenied:
+		c.JSON(http.StatusForbidden, gin.H{"error": st.Message()})
+	case codes.Unavailable, codes.DeadlineExceeded:
+		historyFailure(c, err, http.StatusInternalServerError, st.Message())
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": st.Message()})
+	}
+}
+
+// UpdateLocation stores the location through location-history and publishes
+// it to the live feeds.
+// Requires the caller to be the user, or to hold the admin scope.
+func (s *locationAPI) UpdateLocation(ctx context.Context, req *pb.LocationRequest) (*pb.LocationResponse, error) {
+	if req.GetTimestampUnix() == 0 && req.GetTimestamp() == "" {
+		req.TimestampUnix = time.Now().Unix()
+	}
+	if err := req.Validate(); err != nil {
+		locationUpdates.WithLabelValues(updateRejected).Inc()
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin); err != nil {
+		locationUpdates.WithLabelValues(updateRejected).Inc()
+		return nil, err
+	}
+
+	// location-history is the only writer of user_locations; the row it
+	// stores is what search and the live feeds read.
+	resp, err := locationHistoryClient.UpdateLocation(ctx, req)
+	if err != nil {
+		locationUpdates.WithLabelValues(updateFailed).Inc()
+		return nil, historyError(err, "Failed to communicate with LocationHistory service")
+	}
+
+	locationUpdates.WithLabelValues(updateAccepted).Inc()
+	if !resp.GetDuplicate() {
+		liveFeed.publish(ctx, resp.GetId(), req)
+	}
+	return &pb.LocationResponse{Status: "Success", Id: resp.GetId(), Duplicate: resp.GetDuplicate()}, nil
+}
+
+// SearchUsers returns users within radius kilometers of a point.
+// Requires any authenticated caller. Other users are only returned when
+// their visibility allows it, and their positions are coarsened according
+// to their privacy settings. scope=contacts limits results to the caller's
+// accepted contacts.
+func (s *locationAPI) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if req.GetPage() == 0 {
+		req.Page = 1
+	}
+	if req.GetPageSize() == 0 {
+		req.PageSize = 10
+	}
+	if req.GetScope() == "" {
+		req.Scope = "all"
+	}
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	p := principalFromContext(ctx)
+	if p == nil {
+		return nil, status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	viewer := p.Subject
+
+	// Public positions are only known after fuzzing, so the radius is
+	// checked, counted and paged here rather than in SQL, on the latest
+	// position of each user in a box widened by how far it may have moved.
+	originLat, originLon, radius := req.GetLatitude(), req.GetLongitude(), req.GetRadius()
+	users, err := nearbyUsers(ctx, viewer, req.GetScope(), originLat, originLon, radius*1000+maxPrecisionMeters)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to query database")
+	}
+
+	var matches []*pb.UserLocation
+	for _, u := range users {
+		if u.Distance <= radius {
+			matches = append(matches, &pb.UserLocation{
+				Username:  u.Username,
+				Latitude:  u.Latitude,
+				Longitude: u.Longitude,
+				Distance:  u.Distance,
+			})
+		}
+	}
+
+	resp := &pb.SearchUsersResponse{Total: int32(len(matches))}
+	offset := int((req.GetPage() - 1) * req.GetPageSize())
+	if offset < len(matches) {
+		resp.Users = matches[offset:min(offset+int(req.GetPageSize()), len(matches))]
+	}
+	searchResults.WithLabelValues("search").Observe(float64(len(resp.Users)))
+	return resp, nil
+}
+
+// GetTravelDistance sums the distance between consecutive points of the
+// user's history in a time range. Without start and end the last 24 hours
+// are used; a missing end defaults to now and a missing start to 24 hours
+// before end.
+// Requires the caller to be the user, or to hold the admin or reader scope.
+func (s *locationAPI) GetTravelDistance(ctx context.Context, req *pb.TravelDistanceRequest) (*pb.TravelDistanceResponse, error) {
+	if req.GetEnd() == nil {
+		req.End = timestamppb.Now()
+	}
+	if req.GetStart() == nil {
+		req.Start = timestamppb.New(req.GetEnd().AsTime().Add(-24 * time.Hour))
+	}
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin, scopeReader); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT latitude, longitude, timestamp
+        FROM user_locations
+        WHERE username = $1 AND timestamp BETWEEN $2 AND $3
+        ORDER BY timestamp ASC`,
+		req.GetUsername(), req.GetStart().AsTime(), req.GetEnd().AsTime())
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to query database")
+	}
+	defer rows.Close()
+
+	var totalDistance float64
+	var prevLat, prevLon float64
+	first := true
+
+	for rows.Next() {
+		var latitude, longitude float64
+		var timestamp time.Time
+		if err := rows.Scan(&latitude, &longitude, &timestamp); err != nil {
+			return nil, status.Error(codes.Internal, "Failed to scan row")
+		}
+
+		if !validation.IsValidLatitude(latitude) || !validation.IsValidLongitude(longitude) {
+			return nil, status.Error(codes.FailedPrecondition, "Invalid coordinates")
+		}
+
+		if !first {
+			totalDistance += CalculateDistance(prevLat, prevLon, latitude, longitude)
+		} else {
+			first = false
+		}
+
+		prevLat = latitude
+		prevLon = longitude
+	}
+
+	return &pb.TravelDistanceResponse{
+		Username: req.GetUsername(),
+		Distance: totalDistance,
+		Unit:     "kilometers",
+		Start:    req.GetStart(),
+		End:      req.GetEnd(),
+	}, nil
+}
+
+// GetRetentionStatus reports the last retention run of location-history.
+// Requires the admin scope.
+func (s *locationAPI) GetRetentionStatus(ctx context.Context, req *pb.RetentionStatusRequest) (*pb.RetentionStatusResponse, error) {
+	if p := principalFromContext(ctx); p == nil || !p.hasScope(scopeAdmin) {
+		return nil, status.Error(codes.PermissionDenied, "Insufficient scope")
+	}
+	resp, err := locationHistoryClient.GetRetentionStatus(ctx, req)
+	if err != nil {
+		return nil, historyError(err, "Failed to get retention status from LocationHistory service")
+	}
+	return resp, nil
+}
+
+// SetRetentionOverride keeps the user's history for a number of days other
+// than the default.
+// Requires the admin scope.
+func (s *locationAPI) SetRetentionOverride(ctx context.Context, req *pb.RetentionOverride) (*pb.RetentionOverride, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if p := principalFromContext(ctx); p == nil || !p.hasScope(scopeAdmin) {
+		return nil, status.Error(codes.PermissionDenied, "Insufficient scope")
+	}
+	resp, err := locationHistoryClient.SetRetentionOverride(ctx, req)
+	if err != nil {
+		return nil, historyError(err, "Failed to set retention override in LocationHistory service")
+	}
+	return resp, nil
+}
+
+// DeleteRetentionOverride returns the user to the default retention.
+// Requires the admin scope.
+func (s *locationAPI) DeleteRetentionOverride(ctx context.Context, req *pb.DeleteRetentionOverrideRequest) (*pb.DeleteRetentionOverrideResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if p := principalFromContext(ctx); p == nil || !p.hasScope(scopeAdmin) {
+		return nil, status.Error(codes.PermissionDenied, "Insufficient scope")
+	}
+	resp, err := locationHistoryClient.DeleteRetentionOverride(ctx, req)
+	if status.Code(err) == codes.NotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, historyError(err, "Failed to delete retention override from LocationHistory service")
+	}
+	return resp, nil
+}
+
+// EraseUser erases the user from location-history and then from this
+// service, returning the deleted rows per table. Both steps are idempotent,
+// so a failed call can be retried.
+// Requires the caller to be the user, or to hold the admin scope.
+func (s *locationAPI) EraseUser(ctx context.Context, req *pb.EraseUserRequest) (*pb.EraseUserResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin); err != nil {
+		return nil, err
+	}
+
+	resp, err := locationHistoryClient.EraseUser(ctx, req)
+	if err != nil {
+		return nil, historyError(err, "Failed to erase user from LocationHistory service")
+	}
+
+	deleted := make(map[string]int64)
+	for table, n := range resp.GetDeletedRows() {
+		deleted["history."+table] = n
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to erase user")
+	}
+	defer tx.Rollback()
+
+	for _, t := range managementTables {
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE "+t.where, req.GetUsername())
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Failed to erase user")
+		}
+		deleted["management."+t.table], _ = res.RowsAffected()
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Error(codes.Internal, "Failed to erase user")
+	}
+
+	return &pb.EraseUserResponse{DeletedRows: deleted}, nil
+}
+
+// RegisterDevice registers a GPS unit reporting for the user in
+// location-history.
+// Requires the caller to be the user, or to hold the admin scope.
+func (s *locationAPI) RegisterDevice(ctx context.Context, req *pb.RegisterDeviceRequest) (*pb.Device, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin); err != nil {
+		return nil, err
+	}
+	resp, err := locationHistoryClient.RegisterDevice(ctx, req)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil, err
+	}
+	if err != nil {
+		return nil, historyError(err, "Failed to register device with LocationHistory service")
+	}
+	return resp, nil
+}
+
+// ListDevices returns the devices registered to the user.
+// Requires the caller to be the user, or to hold the admin scope.
+func (s *locationAPI) ListDevices(ctx context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin); err != nil {
+		return nil, err
+	}
+	resp, err := locationHistoryClient.ListDevices(ctx, req)
+	if err != nil {
+		return nil, historyError(err, "Failed to list devices from LocationHistory service")
+	}
+	return resp, nil
+}
+
+// DeleteDevice removes a device of the user.
+// Requires the caller to be the user, or to hold the admin scope.
+func (s *locationAPI) DeleteDevice(ctx context.Context, req *pb.DeleteDeviceRequest) (*pb.DeleteDeviceResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, validation.Status(err)
+	}
+	if err := authorizeContext(ctx, req.GetUsername(), scopeAdmin); err != nil {
+		return nil, err
+	}
+	resp, err := locationHistoryClient.DeleteDevice(ctx, req)
+	if status.Code(err) == codes.NotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, historyError(err, "Failed to delete device from LocationHistory service")
+	}
+	return resp, nil
+}
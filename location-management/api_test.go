@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+type retentionHistoryClient struct {
+	pb.LocationServiceClient
+}
+
+func (retentionHistoryClient) GetRetentionStatus(ctx context.Context, in *pb.RetentionStatusRequest, opts ...grpc.CallOption) (*pb.RetentionStatusResponse, error) {
+	return &pb.RetentionStatusResponse{Mode: "delete", RemovedPoints: 3}, nil
+}
+
+func (retentionHistoryClient) SetRetentionOverride(ctx context.Context, in *pb.RetentionOverride, opts ...grpc.CallOption) (*pb.RetentionOverride, error) {
+	return in, nil
+}
+
+func (retentionHistoryClient) DeleteRetentionOverride(ctx context.Context, in *pb.DeleteRetentionOverrideRequest, opts ...grpc.CallOption) (*pb.DeleteRetentionOverrideResponse, error) {
+	if in.GetUsername() != "keepuser" {
+		return nil, status.Error(codes.NotFound, "retention override not found")
+	}
+	return &pb.DeleteRetentionOverrideResponse{}, nil
+}
+
+type deviceHistoryClient struct {
+	pb.LocationServiceClient
+}
+
+func (deviceHistoryClient) RegisterDevice(ctx context.Context, in *pb.RegisterDeviceRequest, opts ...grpc.CallOption) (*pb.Device, error) {
+	if in.GetDeviceId() == "taken" {
+		return nil, status.Error(codes.AlreadyExists, "device is registered to another user")
+	}
+	return &pb.Device{DeviceId: in.GetDeviceId(), Username: in.GetUsername()}, nil
+}
+
+func (deviceHistoryClient) GetDevice(ctx context.Context, in *pb.GetDeviceRequest, opts ...grpc.CallOption) (*pb.Device, error) {
+	if in.GetDeviceId() != "truck-1" {
+		return nil, status.Error(codes.NotFound, "device not found")
+	}
+	return &pb.Device{DeviceId: "truck-1", Username: "testuser"}, nil
+}
+
+func (deviceHistoryClient) DeleteDevice(ctx context.Context, in *pb.DeleteDeviceRequest, opts ...grpc.CallOption) (*pb.DeleteDeviceResponse, error) {
+	return nil, status.Error(codes.NotFound, "device not found")
+}
+
+func newGatewayTestRouter(subject string, scopes ...string) *gin.Engine {
+	r := gin.New()
+	api := r.Group("/", withPrincipal(subject, scopes...))
+	gateway := gin.WrapH(newGateway())
+	api.POST("/v1/locations", gateway)
+	api.GET("/v1/users/:username/distance", gateway)
+	api.GET("/v1/retention", gateway)
+	api.PUT("/v1/users/:username/retention", gateway)
+	api.DELETE("/v1/users/:username/retention", gateway)
+	api.POST("/v1/users/:username/devices", gateway)
+	api.DELETE("/v1/users/:username/devices/:device_id", gateway)
+	api.GET("/users/distance", CalculateTravelDistance)
+	return r
+}
+
+func doGatewayRequest(r *gin.Engine, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	r.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestGatewayErrors(t *testing.T) {
+	r := newGatewayTestRouter("testuser")
+
+	// Invalid input is reported as a gRPC status with field violations
+	w, resp := doGatewayRequest(r, "POST", "/v1/locations", `{"username": "testuser", "latitude": 100}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, float64(codes.InvalidArgument), resp["code"])
+	assert.Contains(t, resp["message"], "Invalid latitude")
+	assert.Len(t, resp["details"], 1)
+
+	w, resp = doGatewayRequest(r, "GET", "/v1/users/otheruser/distance", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, float64(codes.PermissionDenied), resp["code"])
+
+	w, _ = doGatewayRequest(r, "GET", "/v1/retention", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// useHistoryClient points locationHistoryClient at client for the rest of
+// the test.
+func useHistoryClient(t *testing.T, client pb.LocationServiceClient) {
+	t.Helper()
+	old := locationHistoryClient
+	t.Cleanup(func() { locationHistoryClient = old })
+	locationHistoryClient = client
+}
+
+func TestGatewayRetentionStatus(t *testing.T) {
+	useHistoryClient(t, retentionHistoryClient{})
+	r := newGatewayTestRouter("admin", scopeAdmin)
+
+	w, resp := doGatewayRequest(r, "GET", "/v1/retention", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "delete", resp["mode"])
+	assert.Equal(t, "3", resp["removed_points"])
+}
+
+func TestGatewayRetentionOverrides(t *testing.T) {
+	useHistoryClient(t, retentionHistoryClient{})
+	r := newGatewayTestRouter("admin", scopeAdmin)
+
+	w, resp := doGatewayRequest(r, "PUT", "/v1/users/keepuser/retention", `{"retain_days": 30}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "keepuser", resp["username"])
+	assert.Equal(t, float64(30), resp["retain_days"])
+
+	w, resp = doGatewayRequest(r, "PUT", "/v1/users/keepuser/retention", `{"retain_days": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "Invalid retain_days. Must be at least 0")
+
+	w, _ = doGatewayRequest(r, "DELETE", "/v1/users/keepuser/retention", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = doGatewayRequest(r, "DELETE", "/v1/users/otheruser/retention", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Users cannot change their own retention
+	r = newGatewayTestRouter("keepuser")
+	w, _ = doGatewayRequest(r, "PUT", "/v1/users/keepuser/retention", `{"retain_days": 0}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = doGatewayRequest(r, "DELETE", "/v1/users/keepuser/retention", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGatewayDevices(t *testing.T) {
+	useHistoryClient(t, deviceHistoryClient{})
+	r := newGatewayTestRouter("testuser")
+
+	w, resp := doGatewayRequest(r, "POST", "/v1/users/testuser/devices", `{"device_id": "truck-1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "truck-1", resp["device_id"])
+	assert.Equal(t, "testuser", resp["username"])
+
+	w, _ = doGatewayRequest(r, "POST", "/v1/users/testuser/devices", `{"device_id": "taken"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, resp = doGatewayRequest(r, "POST", "/v1/users/testuser/devices", `{"device_id": "truck 1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], "Invalid device ID")
+
+	w, _ = doGatewayRequest(r, "POST", "/v1/users/otheruser/devices", `{"device_id": "truck-1"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = doGatewayRequest(r, "DELETE", "/v1/users/testuser/devices/truck-2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCompatibilityRouteErrors(t *testing.T) {
+	r := newGatewayTestRouter("testuser")
+
+	// The gin routes keep their own error shape
+	w, resp := doGatewayRequest(r, "GET", "/users/distance?username=otheruser", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "Not allowed to access this user's data", resp["error"])
+
+	w, resp = doGatewayRequest(r, "GET", "/users/distance?username=test@user", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["error"], "Invalid username")
+	assert.Len(t, resp["fields"], 1)
+}
+
+type updateHistoryClient struct {
+	pb.LocationServiceClient
+	got *pb.LocationRequest
+}
+
+func (h *updateHistoryClient) UpdateLocation(ctx context.Context, in *pb.LocationRequest, opts ...grpc.CallOption) (*pb.LocationResponse, error) {
+	h.got = in
+	return &pb.LocationResponse{Status: "Success", Id: 1}, nil
+}
+
+func TestGatewayLegacyTimestamp(t *testing.T) {
+	history := &updateHistoryClient{}
+	useHistoryClient(t, history)
+	r := newGatewayTestRouter("testuser")
+
+	w, _ := doGatewayRequest(r, "POST", "/v1/locations", `{"username": "testuser", "latitude": 1, "longitude": 2, "timestamp_unix": 1617188765}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1617188765), history.got.UnixTimestamp())
+
+	// Older clients send a string of Unix seconds or RFC 3339 instead
+	w, _ = doGatewayRequest(r, "POST", "/v1/locations", `{"username": "testuser", "latitude": 1, "longitude": 2, "timestamp": "2021-03-31T11:06:05Z"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1617188765), history.got.UnixTimestamp())
+
+	w, resp := doGatewayRequest(r, "POST", "/v1/locations", `{"username": "testuser", "latitude": 1, "longitude": 2, "timestamp": "yesterday"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, resp["message"], validation.MsgTimestamp)
+
+	// The string keeps field number 4 on the wire
+	var legacy []byte
+	legacy = protowire.AppendTag(legacy, 4, protowire.BytesType)
+	legacy = protowire.AppendString(legacy, "1617188765")
+	var req pb.LocationRequest
+	assert.NoError(t, proto.Unmarshal(legacy, &req))
+	assert.Equal(t, int64(1617188765), req.UnixTimestamp())
+}
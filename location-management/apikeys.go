@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+var errAPIKeyNotFound = errors.New("api key not found")
+
+type apiKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Username  string     `json:"username"`
+	Scopes    []string   `json:"scopes"`
+	RateLimit float64    `json:"rate_limit"`
+	Burst     int        `json:"burst"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// apiKeyStore authenticates API keys against the api_keys table and keeps
+// one token bucket per key.
+type apiKeyStore struct {
+	defaultRate  float64
+	defaultBurst int
+
+	mu       sync.Mutex
+	limiters map[int64]*rate.Limiter
+}
+
+// newAPIKeyStore uses defaultRate (requests per second) and defaultBurst
+// for keys created without their own limits.
+func newAPIKeyStore(defaultRate float64, defaultBurst int) *apiKeyStore {
+	return &apiKeyStore{
+		defaultRate:  defaultRate,
+		defaultBurst: defaultBurst,
+		limiters:     make(map[int64]*rate.Limiter),
+	}
+}
+
+func ensureAPIKeysTable() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS api_keys (
+            id SERIAL PRIMARY KEY,
+            name TEXT NOT NULL,
+            username TEXT NOT NULL,
+            key_hash TEXT NOT NULL UNIQUE,
+            scopes TEXT NOT NULL DEFAULT '',
+            rate_limit DOUBLE PRECISION NOT NULL,
+            burst INTEGER NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            expires_at TIMESTAMP,
+            revoked_at TIMESTAMP
+        )`)
+	return err
+}
+
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "lmk_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*apiKey, error) {
+	var k apiKey
+	var scopes string
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.Username, &scopes, &k.RateLimit, &k.Burst,
+		&k.CreatedAt, &expiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+const apiKeyColumns = "id, name, username, scopes, rate_limit, burst, created_at, expires_at, revoked_at"
+
+// lookup returns the active key matching raw, or errAPIKeyNotFound when it
+// is unknown, expired or revoked.
+func (s *apiKeyStore) lookup(ctx context.Context, raw string) (*apiKey, error) {
+	row := db.DB.QueryRowContext(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE key_hash = $1
+          AND revoked_at IS NULL
+          AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashAPIKey(raw))
+	k, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAPIKeyNotFound
+	}
+	return k, err
+}
+
+// allow takes a token from the key's bucket. When the bucket is empty it
+// returns false and how long the caller should wait before retrying.
+func (s *apiKeyStore) allow(k *apiKey) (time.Duration, bool) {
+	s.mu.Lock()
+	lim, ok := s.limiters[k.ID]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(k.RateLimit), k.Burst)
+		s.limiters[k.ID] = lim
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	r := lim.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Second, false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// authenticate handles requests carrying an X-API-Key header.
+func (s *apiKeyStore) authenticate(c *gin.Context, raw string) {
+	k, err := s.lookup(c.Request.Context(), raw)
+	if errors.Is(err, errAPIKeyNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	if wait, ok := s.allow(k); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		return
+	}
+
+	setPrincipal(c, &principal{Subject: k.Username, Scopes: k.Scopes})
+	c.Next()
+}
+
+func (s *apiKeyStore) forget(id int64) {
+	s.mu.Lock()
+	delete(s.limiters, id)
+	s.mu.Unlock()
+}
+
+// createAPIKey handles POST /admin/api-keys.
+// Requires the admin scope. The plaintext key is only returned here.
+func (s *apiKeyStore) createAPIKey(c *gin.Context) {
+	var request struct {
+		Name      string     `json:"name"`
+		Username  string     `json:"username"`
+		Scopes    []string   `json:"scopes"`
+		RateLimit float64    `json:"rate_limit"`
+		Burst     int        `json:"burst"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		invalidBody(c, err)
+		return
+	}
+	var v validation.Validator
+	v.Required("name", request.Name)
+	v.Username("username", request.Username)
+	v.NotNegative("rate_limit", &request.RateLimit)
+	v.AtLeast("burst", int64(request.Burst), 0)
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if request.RateLimit == 0 {
+		request.RateLimit = s.defaultRate
+	}
+	if request.Burst == 0 {
+		request.Burst = s.defaultBurst
+	}
+
+	raw, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	row := db.DB.QueryRowContext(c.Request.Context(), `
+        INSERT INTO api_keys (name, username, key_hash, scopes, rate_limit, burst, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING `+apiKeyColumns,
+		request.Name, request.Username, hashAPIKey(raw), strings.Join(request.Scopes, " "),
+		request.RateLimit, request.Burst, request.ExpiresAt)
+	k, err := scanAPIKey(row)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": raw, "api_key": k})
+}
+
+// listAPIKeys handles GET /admin/api-keys.
+// Requires the admin scope.
+func (s *apiKeyStore) listAPIKeys(c *gin.Context) {
+	rows, err := db.DB.QueryContext(c.Request.Context(), `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []*apiKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan row"})
+			return
+		}
+		keys = append(keys, k)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// revokeAPIKey handles DELETE /admin/api-keys/:id.
+// Requires the admin scope.
+func (s *apiKeyStore) revokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key id"})
+		return
+	}
+
+	res, err := db.DB.ExecContext(c.Request.Context(), "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	s.forget(id)
+
+	c.JSON(http.StatusOK, gin.H{"status": "API key revoked"})
+}
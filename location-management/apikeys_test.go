@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	a, err := generateAPIKey()
+	assert.NoError(t, err)
+	b, err := generateAPIKey()
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(a, "lmk_"))
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, hashAPIKey(a), hashAPIKey(a))
+	assert.NotEqual(t, hashAPIKey(a), hashAPIKey(b))
+	assert.Len(t, hashAPIKey(a), 64)
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	s := newAPIKeyStore(10, 20)
+	k := &apiKey{ID: 1, RateLimit: 1, Burst: 2}
+
+	_, ok := s.allow(k)
+	assert.True(t, ok)
+	_, ok = s.allow(k)
+	assert.True(t, ok)
+
+	wait, ok := s.allow(k)
+	assert.False(t, ok)
+	assert.Greater(t, wait.Seconds(), 0.0)
+
+	// Buckets are tracked per key
+	_, ok = s.allow(&apiKey{ID: 2, RateLimit: 1, Burst: 1})
+	assert.True(t, ok)
+
+	// Revoking a key drops its bucket
+	s.forget(1)
+	_, ok = s.allow(k)
+	assert.True(t, ok)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/telemetry"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	scopeAdmin  = "admin"
+	scopeReader = "reader"
+
+	principalKey = "principal"
+)
+
+type principalContextKey struct{}
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	Subject string
+	Scopes  []string
+}
+
+func (p *principal) hasScope(scopes ...string) bool {
+	for _, s := range scopes {
+		if slices.Contains(p.Scopes, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope"`
+}
+
+type authenticator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+	apiKeys    *apiKeyStore
+}
+
+// newAuthenticator configures JWT validation with an HS256 secret and/or
+// the RS256 keys of a JWKS file, with optional issuer and audience checks.
+func newAuthenticator(cfg config.Auth) (*authenticator, error) {
+	a := &authenticator{
+		hmacSecret: []byte(cfg.HS256Secret),
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+	}
+	if len(a.hmacSecret) == 0 && len(a.rsaKeys) == 0 {
+		return nil, errors.New("no JWT keys configured")
+	}
+	return a, nil
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS file contains no RSA keys")
+	}
+	return keys, nil
+}
+
+func (a *authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(a.hmacSecret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return a.hmacSecret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, key := range a.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+}
+
+func (a *authenticator) parse(tokenString string) (*principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+	}
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	var claims tokenClaims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, a.keyFunc, opts...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &principal{Subject: claims.Subject, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+// acceptsEventStream reports whether r asks for server-sent events, as
+// EventSource requests do.
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware rejects requests without a valid API key or bearer token and
+// stores the caller's principal on the context for handlers to authorize
+// against.
+func (a *authenticator) middleware() gin.HandlerFunc {
+	return a.authenticate(false)
+}
+
+// streamMiddleware is middleware for the live feeds. Browsers cannot set
+// headers on a WebSocket handshake or an EventSource request, so those
+// requests may also pass the bearer token as access_token. No other route
+// accepts it, as URLs end up in proxy and access logs.
+func (a *authenticator) streamMiddleware() gin.HandlerFunc {
+	return a.authenticate(true)
+}
+
+func (a *authenticator) authenticate(queryToken bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(apiKeyHeader); key != "" && a.apiKeys != nil {
+			a.apiKeys.authenticate(c, key)
+			return
+		}
+		// Apps such as OwnTracks can only send a username and password, so
+		// an API key is also accepted as the Basic auth password.
+		if _, key, ok := c.Request.BasicAuth(); ok && key != "" && a.apiKeys != nil {
+			a.apiKeys.authenticate(c, key)
+			return
+		}
+
+		tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok && queryToken && (websocket.IsWebSocketUpgrade(c.Request) || acceptsEventStream(c.Request)) {
+			tokenString, ok = c.Query("access_token"), true
+		}
+		if !ok || tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+		p, err := a.parse(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		setPrincipal(c, p)
+		c.Next()
+	}
+}
+
+// setPrincipal records the authenticated caller for handlers and adds the
+// username to the request context for logging.
+func setPrincipal(c *gin.Context, p *principal) {
+	c.Set(principalKey, p)
+	ctx := context.WithValue(c.Request.Context(), principalContextKey{}, p)
+	c.Request = c.Request.WithContext(telemetry.WithUsername(ctx, p.Subject))
+}
+
+// requireScope rejects callers holding none of scopes.
+func requireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := currentPrincipal(c)
+		if p == nil || !p.hasScope(scopes...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func currentPrincipal(c *gin.Context) *principal {
+	p, _ := c.Get(principalKey)
+	pr, _ := p.(*principal)
+	return pr
+}
+
+// principalFromContext returns the caller stored by setPrincipal, for code
+// such as the LocationService implementation that only sees the request
+// context.
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// authorizeContext is authorizeUser for the LocationService implementation:
+// it returns a gRPC status instead of responding.
+func authorizeContext(ctx context.Context, username string, scopes ...string) error {
+	p := principalFromContext(ctx)
+	if p == nil {
+		return status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	if p.Subject == username || p.hasScope(scopes...) {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, "Not allowed to access this user's data")
+}
+
+// authorizeUser allows the request when the caller is username itself or
+// holds one of scopes, and writes the error response otherwise.
+func authorizeUser(c *gin.Context, username string, scopes ...string) bool {
+	p := currentPrincipal(c)
+	if p == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return false
+	}
+	if p.Subject == username || p.hasScope(scopes...) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to access this user's data"})
+	return false
+}
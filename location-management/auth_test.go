@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func withPrincipal(subject string, scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setPrincipal(c, &principal{Subject: subject, Scopes: scopes})
+	}
+}
+
+func signHS256(t *testing.T, secret, subject, scope string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: scope,
+	})
+	s, err := token.SignedString([]byte(secret))
+	assert.NoError(t, err)
+	return s
+}
+
+func newAuthTestRouter(a *authenticator) *gin.Engine {
+	r := gin.New()
+	r.GET("/users/:username", a.middleware(), func(c *gin.Context) {
+		if !authorizeUser(c, c.Param("username"), scopeAdmin, scopeReader) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	return r
+}
+
+func doAuthRequest(r *gin.Engine, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddlewareHS256(t *testing.T) {
+	a, err := newAuthenticator(config.Auth{HS256Secret: "test-secret"})
+	assert.NoError(t, err)
+	r := newAuthTestRouter(a)
+
+	// Missing token
+	w := doAuthRequest(r, "/users/testuser", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Wrong signature
+	w = doAuthRequest(r, "/users/testuser", signHS256(t, "other-secret", "testuser", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Own data
+	w = doAuthRequest(r, "/users/testuser", signHS256(t, "test-secret", "testuser", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Someone else's data
+	w = doAuthRequest(r, "/users/otheruser", signHS256(t, "test-secret", "testuser", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Someone else's data with reader scope
+	w = doAuthRequest(r, "/users/otheruser", signHS256(t, "test-secret", "testuser", "reader"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthMiddlewareRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	a, err := newAuthenticator(config.Auth{JWKSFile: path})
+	assert.NoError(t, err)
+	r := newAuthTestRouter(a)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "testuser",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	w := doAuthRequest(r, "/users/testuser", signed)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// HS256 is rejected when no shared secret is configured
+	w = doAuthRequest(r, "/users/testuser", signHS256(t, "test-secret", "testuser", ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddlewareQueryToken(t *testing.T) {
+	a, err := newAuthenticator(config.Auth{HS256Secret: "test-secret"})
+	assert.NoError(t, err)
+	r := newAuthTestRouter(a)
+	r.GET("/live/:username", a.streamMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	query := "?access_token=" + signHS256(t, "test-secret", "testuser", "")
+	eventStream := func(path string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Only on the live feeds, and only where browsers cannot send the header
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(r, "/live/testuser"+query, "").Code)
+	assert.Equal(t, http.StatusOK, eventStream("/live/testuser"+query))
+	assert.Equal(t, http.StatusUnauthorized, eventStream("/users/testuser"+query))
+}
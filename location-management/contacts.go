@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	contactPending  = "pending"
+	contactAccepted = "accepted"
+)
+
+type contact struct {
+	Username   string     `json:"username"`
+	Status     string     `json:"status"`
+	Direction  string     `json:"direction"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+func ensureContactsTable() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS contacts (
+            requester TEXT NOT NULL,
+            addressee TEXT NOT NULL,
+            status TEXT NOT NULL DEFAULT 'pending',
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            accepted_at TIMESTAMP,
+            PRIMARY KEY (requester, addressee),
+            CHECK (requester <> addressee)
+        )`)
+	return err
+}
+
+// isContactOf returns a SQL condition that is true when the user in column
+// userColumn and the user bound at parameter viewerParam are accepted
+// contacts.
+func isContactOf(userColumn string, viewerParam int) string {
+	return fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM contacts c
+            WHERE c.status = 'accepted'
+              AND ((c.requester = $%[2]d AND c.addressee = %[1]s)
+                OR (c.requester = %[1]s AND c.addressee = $%[2]d)))`,
+		userColumn, viewerParam)
+}
+
+// scopeFilter narrows a search to the viewer's contacts for scope=contacts.
+func scopeFilter(scope, userColumn string, viewerParam int) string {
+	if scope == "contacts" {
+		return " AND " + isContactOf(userColumn, viewerParam)
+	}
+	return ""
+}
+
+func listContacts(ctx context.Context, q querier, username string) ([]contact, error) {
+	rows, err := q.QueryContext(ctx, `
+        SELECT CASE WHEN requester = $1 THEN addressee ELSE requester END,
+               status,
+               CASE WHEN requester = $1 THEN 'outgoing' ELSE 'incoming' END,
+               created_at, accepted_at
+        FROM contacts
+        WHERE requester = $1 OR addressee = $1
+        ORDER BY created_at`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []contact{}
+	for rows.Next() {
+		var ct contact
+		var acceptedAt sql.NullTime
+		if err := rows.Scan(&ct.Username, &ct.Status, &ct.Direction, &ct.CreatedAt, &acceptedAt); err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			ct.AcceptedAt = &acceptedAt.Time
+		}
+		contacts = append(contacts, ct)
+	}
+	return contacts, rows.Err()
+}
+
+// getContacts handles GET /users/:username/contacts.
+// Requires the caller to be the user, or to hold the admin scope.
+func getContacts(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	contacts, err := listContacts(c.Request.Context(), db.DB, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "contacts": contacts})
+}
+
+// sendContactRequest handles POST /users/:username/contacts.
+// Requires the caller to be the user, or to hold the admin scope. When the
+// other user already sent a request, it is accepted instead.
+func sendContactRequest(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+
+	var request struct {
+		Contact string `json:"contact"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var v validation.Validator
+	v.Username("contact", request.Contact)
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if request.Contact == username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot add yourself as a contact"})
+		return
+	}
+
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	res, err := db.DB.ExecContext(c.Request.Context(), `
+        UPDATE contacts SET status = 'accepted', accepted_at = NOW()
+        WHERE requester = $1 AND addressee = $2 AND status = 'pending'`,
+		request.Contact, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		c.JSON(http.StatusOK, gin.H{"username": request.Contact, "status": contactAccepted})
+		return
+	}
+
+	var status string
+	err = db.DB.QueryRowContext(c.Request.Context(), `
+        SELECT status FROM contacts
+        WHERE (requester = $1 AND addressee = $2) OR (requester = $2 AND addressee = $1)`,
+		username, request.Contact).Scan(&status)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Contact request already exists", "status": status})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+		return
+	}
+
+	_, err = db.DB.ExecContext(c.Request.Context(), "INSERT INTO contacts (requester, addressee) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		username, request.Contact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"username": request.Contact, "status": contactPending})
+}
+
+// acceptContactRequest handles POST /users/:username/contacts/:contact/accept.
+// Requires the caller to be the user, or to hold the admin scope.
+func acceptContactRequest(c *gin.Context) {
+	username := c.Param("username")
+	other := c.Param("contact")
+	if !validUsernameParams(c, "username", "contact") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	res, err := db.DB.ExecContext(c.Request.Context(), `
+        UPDATE contacts SET status = 'accepted', accepted_at = NOW()
+        WHERE requester = $1 AND addressee = $2 AND status = 'pending'`,
+		other, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept contact request"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending contact request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": other, "status": contactAccepted})
+}
+
+// removeContact handles DELETE /users/:username/contacts/:contact.
+// Requires the caller to be the user, or to hold the admin scope. Removes an
+// accepted contact, or declines or withdraws a pending request.
+func removeContact(c *gin.Context) {
+	username := c.Param("username")
+	other := c.Param("contact")
+	if !validUsernameParams(c, "username", "contact") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	res, err := db.DB.ExecContext(c.Request.Context(), `
+        DELETE FROM contacts
+        WHERE (requester = $1 AND addressee = $2) OR (requester = $2 AND addressee = $1)`,
+		username, other)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove contact"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "contact removed"})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContacts(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+
+	db.DB = testDB
+	assert.NoError(t, ensureUserSettingsTable())
+	assert.NoError(t, ensureContactsTable())
+	testDB.Exec("DELETE FROM contacts WHERE requester IN ('alice1', 'bob1') OR addressee IN ('alice1', 'bob1')")
+
+	r := gin.Default()
+	alice := r.Group("/alice", withPrincipal("alice1"))
+	alice.POST("/users/:username/contacts", sendContactRequest)
+	alice.GET("/users/search", searchUsers)
+	bob := r.Group("/bob", withPrincipal("bob1"))
+	bob.POST("/users/:username/contacts/:contact/accept", acceptContactRequest)
+	bob.GET("/users/:username/contacts", getContacts)
+
+	// Send a request
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]interface{}{"contact": "bob1"})
+	req, _ := http.NewRequest("POST", "/alice/users/alice1/contacts", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Cannot send on behalf of someone else
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/alice/users/bob1/contacts", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Accept it
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/bob/users/bob1/contacts/alice1/accept", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/bob/users/bob1/contacts", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"username":"alice1","status":"accepted","direction":"incoming"`)
+
+	// Contacts-only users are found by their contacts
+	_, err := testDB.Exec("INSERT INTO user_locations (username, latitude, longitude) VALUES ($1, $2, $3)",
+		"bob1", 48.8566, 2.3522)
+	assert.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO user_settings (username, visibility) VALUES ($1, $2)
+        ON CONFLICT (username) DO UPDATE SET visibility = EXCLUDED.visibility`, "bob1", "contacts")
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/alice/users/search?latitude=48.8566&longitude=2.3522&radius=1&scope=contacts", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "bob1")
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/gin-gonic/gin"
+)
+
+// tripGap is the pause between two points that starts a new trip.
+const tripGap = 30 * time.Minute
+
+// receiptKey signs deletion receipts. It is derived from the configured
+// base64 Ed25519 seed, so receipts stay verifiable across restarts against
+// the key served by receiptPublicKey.
+var receiptKey ed25519.PrivateKey
+
+func initReceiptSigner(seed string) error {
+	b, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(b) != ed25519.SeedSize {
+		return errors.New("the receipt signing key must be a base64 encoded 32 byte Ed25519 seed")
+	}
+	receiptKey = ed25519.NewKeyFromSeed(b)
+	return nil
+}
+
+// querier is the part of *sql.DB and *sql.Tx that reads need, so a read can
+// join the export's transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type locationPoint struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type trip struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Points   int       `json:"points"`
+	Distance float64   `json:"distance"`
+}
+
+// tripBuilder splits a time-ordered stream of points into trips.
+type tripBuilder struct {
+	trips   []trip
+	current *trip
+	prev    locationPoint
+}
+
+func (b *tripBuilder) add(p locationPoint) {
+	if b.current != nil && p.Timestamp.Sub(b.prev.Timestamp) <= tripGap {
+		b.current.End = p.Timestamp
+		b.current.Points++
+		b.current.Distance += CalculateDistance(b.prev.Latitude, b.prev.Longitude, p.Latitude, p.Longitude)
+	} else {
+		b.flush()
+		b.current = &trip{Start: p.Timestamp, End: p.Timestamp, Points: 1}
+	}
+	b.prev = p
+}
+
+func (b *tripBuilder) flush() {
+	if b.current != nil && b.current.Points > 1 {
+		b.trips = append(b.trips, *b.current)
+	}
+	b.current = nil
+}
+
+func (b *tripBuilder) result() []trip {
+	b.flush()
+	if b.trips == nil {
+		return []trip{}
+	}
+	return b.trips
+}
+
+// exportUser handles GET /users/:username/export.
+// Requires the caller to be the user, or to hold the admin scope. Streams a
+// zip with the user's history, derived trips and settings, and what
+// location-history stores about them. The location-management part is read
+// from one snapshot, so its files agree with each other.
+func exportUser(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(c.Request.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer tx.Rollback()
+
+	settings, err := exportSettings(c.Request.Context(), tx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	// The first chunk is read before the response starts, so an unavailable
+	// location-history is still reported with a status code.
+	history, err := locationHistoryClient.ExportUser(c.Request.Context(), &pb.ExportUserRequest{Username: username})
+	var first *pb.ExportUserChunk
+	if err == nil {
+		first, err = history.Recv()
+	}
+	if err != nil {
+		historyFailure(c, err, http.StatusInternalServerError, "Failed to export history")
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-export.zip"`, username))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	err = writeExport(c.Request.Context(), tx, zw, username, settings)
+	if err == nil {
+		err = writeHistoryExport(zw, first, history.Recv)
+	}
+	if err != nil {
+		// Headers are already sent, so the truncated archive is the only
+		// signal the client gets.
+		slog.ErrorContext(c.Request.Context(), "Export failed", "error", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Export failed", "error", err)
+	}
+}
+
+func writeExport(ctx context.Context, q querier, zw *zip.Writer, username string, settings map[string]interface{}) error {
+	// A zip entry must be complete before the next one starts, so history
+	// is read once per format instead of being buffered.
+	jsonFile, err := zw.Create("history.json")
+	if err != nil {
+		return err
+	}
+	var trips tripBuilder
+	enc := json.NewEncoder(jsonFile)
+	sep := ""
+	fmt.Fprint(jsonFile, "[")
+	err = forEachPoint(ctx, q, username, func(p locationPoint) error {
+		fmt.Fprint(jsonFile, sep)
+		sep = ","
+		trips.add(p)
+		return enc.Encode(p)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(jsonFile, "]")
+
+	csvFile, err := zw.Create("history.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(csvFile)
+	cw.Write([]string{"latitude", "longitude", "timestamp"})
+	err = forEachPoint(ctx, q, username, func(p locationPoint) error {
+		return cw.Write([]string{
+			strconv.FormatFloat(p.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(p.Longitude, 'f', -1, 64),
+			p.Timestamp.Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	if err := writeJSONEntry(zw, "trips.json", trips.result()); err != nil {
+		return err
+	}
+	return writeJSONEntry(zw, "settings.json", settings)
+}
+
+// forEachPoint calls fn for every stored point of username in time order.
+func forEachPoint(ctx context.Context, q querier, username string, fn func(locationPoint) error) error {
+	rows, err := q.QueryContext(ctx, `
+        SELECT latitude, longitude, timestamp
+        FROM user_locations
+        WHERE username = $1
+        ORDER BY timestamp ASC`, username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p locationPoint
+		if err := rows.Scan(&p.Latitude, &p.Longitude, &p.Timestamp); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+type historyExportLocation struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"`
+	Accuracy  *float64  `json:"accuracy,omitempty"`
+	Altitude  *float64  `json:"altitude,omitempty"`
+	Speed     *float64  `json:"speed,omitempty"`
+}
+
+type historyExportDevice struct {
+	DeviceID  string    `json:"device_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type historyExportDay struct {
+	Day          string    `json:"day"`
+	Points       int32     `json:"points"`
+	AvgLatitude  float64   `json:"avg_latitude"`
+	AvgLongitude float64   `json:"avg_longitude"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// writeHistoryExport adds location-history's data to the archive under
+// location-history/. first carries devices, the retention override and
+// daily summaries; it and the chunks from recv carry locations.
+func writeHistoryExport(zw *zip.Writer, first *pb.ExportUserChunk, recv func() (*pb.ExportUserChunk, error)) error {
+	devices := []historyExportDevice{}
+	for _, d := range first.GetDevices() {
+		devices = append(devices, historyExportDevice{DeviceID: d.GetDeviceId(), CreatedAt: d.GetCreatedAt().AsTime()})
+	}
+	if err := writeJSONEntry(zw, "location-history/devices.json", devices); err != nil {
+		return err
+	}
+	// retain_days is null while the default retention applies.
+	if err := writeJSONEntry(zw, "location-history/retention.json", map[string]*int32{"retain_days": first.RetainDays}); err != nil {
+		return err
+	}
+	daily := []historyExportDay{}
+	for _, d := range first.GetDaily() {
+		daily = append(daily, historyExportDay{
+			Day:          d.GetDay(),
+			Points:       d.GetPoints(),
+			AvgLatitude:  d.GetAvgLatitude(),
+			AvgLongitude: d.GetAvgLongitude(),
+			FirstSeen:    d.GetFirstSeen().AsTime(),
+			LastSeen:     d.GetLastSeen().AsTime(),
+		})
+	}
+	if err := writeJSONEntry(zw, "location-history/daily.json", daily); err != nil {
+		return err
+	}
+
+	f, err := zw.Create("location-history/locations.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	sep := ""
+	fmt.Fprint(f, "[")
+	for chunk := first; ; {
+		for _, l := range chunk.GetLocations() {
+			fmt.Fprint(f, sep)
+			sep = ","
+			err := enc.Encode(historyExportLocation{
+				Latitude:  l.GetLatitude(),
+				Longitude: l.GetLongitude(),
+				Timestamp: time.Unix(l.GetTimestamp(), 0).UTC(),
+				Accuracy:  l.Accuracy,
+				Altitude:  l.Altitude,
+				Speed:     l.Speed,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		chunk, err = recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprint(f, "]")
+	return err
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func exportSettings(ctx context.Context, q querier, username string) (map[string]interface{}, error) {
+	privacy, err := getPrivacySetting(ctx, q, username)
+	if err != nil {
+		return nil, err
+	}
+	visibility, err := getVisibility(ctx, q, username)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := listContacts(ctx, q, username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE username = $1 ORDER BY id`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*apiKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return map[string]interface{}{
+		"username":   username,
+		"privacy":    privacy,
+		"visibility": visibility,
+		"contacts":   contacts,
+		"api_keys":   keys,
+	}, rows.Err()
+}
+
+type deletionReceipt struct {
+	Username    string           `json:"username"`
+	ErasedAt    time.Time        `json:"erased_at"`
+	DeletedRows map[string]int64 `json:"deleted_rows"`
+}
+
+// signReceipt returns the receipt as JSON together with its Ed25519
+// signature over exactly those bytes.
+func signReceipt(r deletionReceipt) (json.RawMessage, string, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, "", err
+	}
+	sig := ed25519.Sign(receiptKey, payload)
+	return payload, base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// managementTables maps every location-management table holding per-user
+// rows to the condition selecting a user's rows.
+var managementTables = []struct {
+	table string
+	where string
+}{
+	{"user_locations", "username = $1"},
+	{"user_settings", "username = $1"},
+	{"api_keys", "username = $1"},
+	{"contacts", "requester = $1 OR addressee = $1"},
+	{"import_jobs", "username = $1"},
+}
+
+// eraseUser handles DELETE /users/:username.
+// Requires the caller to be the user, or to hold the admin scope. Erases the
+// user through locationService.EraseUser and returns a signed deletion
+// receipt.
+func eraseUser(c *gin.Context) {
+	username := c.Param("username")
+	resp, err := locationService.EraseUser(c.Request.Context(), &pb.EraseUserRequest{Username: username})
+	if err != nil {
+		respondRPCError(c, err)
+		return
+	}
+
+	receipt, sig, err := signReceipt(deletionReceipt{
+		Username:    username,
+		ErasedAt:    time.Now().UTC(),
+		DeletedRows: resp.GetDeletedRows(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign deletion receipt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"receipt":   receipt,
+		"signature": sig,
+		"algorithm": "Ed25519",
+	})
+}
+
+// receiptPublicKey handles GET /v1/receipts/public-key.
+// Needs no authentication. Returns the key that verifies deletion receipts.
+func receiptPublicKey(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":  "Ed25519",
+		"public_key": base64.StdEncoding.EncodeToString(receiptKey.Public().(ed25519.PublicKey)),
+	})
+}
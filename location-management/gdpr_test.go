@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestTripBuilder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	var b tripBuilder
+	b.add(locationPoint{Latitude: 37.7749, Longitude: -122.4194, Timestamp: start})
+	b.add(locationPoint{Latitude: 37.7750, Longitude: -122.4195, Timestamp: start.Add(10 * time.Minute)})
+	b.add(locationPoint{Latitude: 37.7760, Longitude: -122.4200, Timestamp: start.Add(20 * time.Minute)})
+	// A long pause starts a new trip, and single-point trips are dropped
+	b.add(locationPoint{Latitude: 37.8000, Longitude: -122.4000, Timestamp: start.Add(3 * time.Hour)})
+	b.add(locationPoint{Latitude: 37.9000, Longitude: -122.3000, Timestamp: start.Add(6 * time.Hour)})
+	b.add(locationPoint{Latitude: 37.9010, Longitude: -122.3010, Timestamp: start.Add(6*time.Hour + 5*time.Minute)})
+
+	trips := b.result()
+	assert.Len(t, trips, 2)
+	assert.Equal(t, 3, trips[0].Points)
+	assert.Equal(t, start, trips[0].Start)
+	assert.Equal(t, start.Add(20*time.Minute), trips[0].End)
+	assert.Greater(t, trips[0].Distance, 0.0)
+	assert.Equal(t, 2, trips[1].Points)
+
+	var empty tripBuilder
+	assert.Empty(t, empty.result())
+}
+
+func TestSignReceipt(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	receiptKey = key
+
+	payload, sig, err := signReceipt(deletionReceipt{
+		Username:    "testuser",
+		ErasedAt:    time.Now().UTC(),
+		DeletedRows: map[string]int64{"history.user_locations": 3},
+	})
+	assert.NoError(t, err)
+
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	assert.NoError(t, err)
+	assert.True(t, ed25519.Verify(pub, payload, raw))
+
+	var decoded deletionReceipt
+	assert.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.Equal(t, "testuser", decoded.Username)
+	assert.Equal(t, int64(3), decoded.DeletedRows["history.user_locations"])
+}
+
+func TestReceiptPublicKey(t *testing.T) {
+	defer func(key ed25519.PrivateKey) { receiptKey = key }(receiptKey)
+	assert.Error(t, initReceiptSigner(""))
+	seed := make([]byte, ed25519.SeedSize)
+	assert.NoError(t, initReceiptSigner(base64.StdEncoding.EncodeToString(seed)))
+
+	r := newRouter(&authenticator{apiKeys: newAPIKeyStore(1, 1)})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/receipts/public-key", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Algorithm string `json:"algorithm"`
+		PublicKey []byte `json:"public_key"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Ed25519", resp.Algorithm)
+	assert.Equal(t, []byte(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)), resp.PublicKey)
+}
+
+func readZipEntry(t *testing.T, zr *zip.Reader, name string, v interface{}) {
+	t.Helper()
+	f, err := zr.Open(name)
+	if !assert.NoError(t, err, name) {
+		return
+	}
+	defer f.Close()
+	assert.NoError(t, json.NewDecoder(f).Decode(v), name)
+}
+
+func TestWriteHistoryExport(t *testing.T) {
+	seen := time.Date(2023, 1, 1, 8, 0, 0, 0, time.UTC)
+	first := &pb.ExportUserChunk{
+		Devices:    []*pb.Device{{DeviceId: "truck-1", Username: "testuser", CreatedAt: timestamppb.New(seen)}},
+		RetainDays: proto.Int32(7),
+		Daily: []*pb.DailySummary{{
+			Day: "2023-01-01", Points: 10, AvgLatitude: 1.5, AvgLongitude: 2.5,
+			FirstSeen: timestamppb.New(seen), LastSeen: timestamppb.New(seen.Add(time.Hour)),
+		}},
+	}
+	chunks := []*pb.ExportUserChunk{
+		{Locations: []*pb.HistoryLocation{{Latitude: 1, Longitude: 2, Timestamp: 1672560000, Accuracy: proto.Float64(5)}}},
+		{Locations: []*pb.HistoryLocation{{Latitude: 3, Longitude: 4, Timestamp: 1672560060, Speed: proto.Float64(2)}}},
+	}
+	recv := func() (*pb.ExportUserChunk, error) {
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		c := chunks[0]
+		chunks = chunks[1:]
+		return c, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	assert.NoError(t, writeHistoryExport(zw, first, recv))
+	assert.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	var devices []historyExportDevice
+	readZipEntry(t, zr, "location-history/devices.json", &devices)
+	assert.Equal(t, []historyExportDevice{{DeviceID: "truck-1", CreatedAt: seen}}, devices)
+
+	var retention map[string]*int32
+	readZipEntry(t, zr, "location-history/retention.json", &retention)
+	assert.Equal(t, int32(7), *retention["retain_days"])
+
+	var daily []historyExportDay
+	readZipEntry(t, zr, "location-history/daily.json", &daily)
+	assert.Equal(t, []historyExportDay{{
+		Day: "2023-01-01", Points: 10, AvgLatitude: 1.5, AvgLongitude: 2.5, FirstSeen: seen, LastSeen: seen.Add(time.Hour),
+	}}, daily)
+
+	var locations []historyExportLocation
+	readZipEntry(t, zr, "location-history/locations.json", &locations)
+	assert.Equal(t, []historyExportLocation{
+		{Latitude: 1, Longitude: 2, Timestamp: time.Unix(1672560000, 0).UTC(), Accuracy: proto.Float64(5)},
+		{Latitude: 3, Longitude: 4, Timestamp: time.Unix(1672560060, 0).UTC(), Speed: proto.Float64(2)},
+	}, locations)
+
+	// A failure mid-stream fails the export
+	zw = zip.NewWriter(io.Discard)
+	err = writeHistoryExport(zw, &pb.ExportUserChunk{}, func() (*pb.ExportUserChunk, error) {
+		return nil, errors.New("connection reset")
+	})
+	assert.EqualError(t, err, "connection reset")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const readinessTimeout = 2 * time.Second
+
+var (
+	historyHealthClient healthpb.HealthClient
+
+	// shuttingDown makes /readyz fail as soon as shutdown starts, so the
+	// orchestrator stops routing traffic while requests drain.
+	shuttingDown atomic.Bool
+)
+
+// tracedRequest keeps probes, metric scrapes and live streams out of traces.
+// A stream's span would last as long as the connection, and a WebSocket URL
+// may carry an access token.
+func tracedRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return !websocket.IsWebSocketUpgrade(r) && r.Header.Get("Accept") != "text/event-stream"
+}
+
+// healthz handles GET /healthz.
+// It only reports that the process is up.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz handles GET /readyz.
+// It checks the database and the health of the LocationHistory service.
+// The response names only the state of each check; errors are logged, as
+// the probe is unauthenticated.
+func readyz(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	checks := gin.H{"database": "ok", "location_history": "ok"}
+	if err := db.DB.PingContext(ctx); err != nil {
+		ready = false
+		checks["database"] = "unavailable"
+		slog.WarnContext(ctx, "Readiness check failed", "check", "database", "error", err)
+	}
+	resp, err := historyHealthClient.Check(ctx, &healthpb.HealthCheckRequest{
+		Service: pb.LocationService_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		ready = false
+		checks["location_history"] = "unavailable"
+		slog.WarnContext(ctx, "Readiness check failed", "check", "location_history", "error", err)
+	} else if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		ready = false
+		checks["location_history"] = resp.Status.String()
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type fakeHealthClient struct {
+	healthpb.HealthClient
+	status healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (f fakeHealthClient) Check(ctx context.Context, in *healthpb.HealthCheckRequest, opts ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	return &healthpb.HealthCheckResponse{Status: f.status}, nil
+}
+
+func TestHealthz(t *testing.T) {
+	r := gin.New()
+	r.GET("/healthz", healthz)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	// Nothing listens on port 1, so the database ping fails.
+	unreachable, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=test dbname=test sslmode=disable connect_timeout=1")
+	assert.NoError(t, err)
+	defer unreachable.Close()
+	prevDB, prevHealth := db.DB, historyHealthClient
+	t.Cleanup(func() { db.DB, historyHealthClient = prevDB, prevHealth })
+	db.DB = unreachable
+	historyHealthClient = fakeHealthClient{status: healthpb.HealthCheckResponse_NOT_SERVING}
+
+	r := gin.New()
+	r.GET("/readyz", readyz)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var body struct {
+		Checks map[string]string `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "unavailable", body.Checks["database"])
+	assert.NotContains(t, w.Body.String(), "127.0.0.1")
+	assert.Equal(t, "NOT_SERVING", body.Checks["location_history"])
+
+	shuttingDown.Store(true)
+	defer shuttingDown.Store(false)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "shutting down")
+}
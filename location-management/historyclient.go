@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Health checks carry their own timeout and must keep probing history while
+// the breaker is open, so they bypass the resilience interceptor.
+const historyHealthPrefix = "/grpc.health.v1.Health/"
+
+var historyCalls *historyCaller
+
+// historyCaller bounds, retries and short-circuits calls to the
+// LocationHistory service.
+type historyCaller struct {
+	callTimeout time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	breaker     *circuitBreaker
+}
+
+func newHistoryCaller(cfg config.HistoryClient) *historyCaller {
+	return &historyCaller{
+		callTimeout: time.Duration(cfg.CallTimeout),
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     time.Duration(cfg.RetryBackoff),
+		maxBackoff:  time.Duration(cfg.MaxRetryBackoff),
+		breaker:     newCircuitBreaker(cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)),
+	}
+}
+
+// retryable reports whether a failed call is worth repeating. These codes
+// are transient but do not prove the handler never ran: the connection can
+// drop after history committed. Repeating is still safe because the
+// LocationService writes are idempotent, with locations unique per user and
+// second; a repeated delete may report NotFound.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// delay returns the jittered backoff before the given retry (1 for the
+// first): exponential up to maxBackoff, then randomised over its upper half
+// so clients that failed together do not retry together.
+func (h *historyCaller) delay(retry int) time.Duration {
+	d := h.backoff << (retry - 1)
+	if d > h.maxBackoff || d <= 0 {
+		d = h.maxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// unaryInterceptor gives each attempt callTimeout, bounded by the caller's
+// context, and retries retryable failures while the breaker lets calls
+// through.
+func (h *historyCaller) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if strings.HasPrefix(method, historyHealthPrefix) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := h.breaker.allow(); err != nil {
+			return err
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, h.callTimeout)
+		err := invoker(callCtx, method, req, reply, cc, opts...)
+		cancel()
+		h.breaker.record(err)
+
+		if err == nil || attempt >= h.maxAttempts || !retryable(err) {
+			return err
+		}
+		historyRetries.WithLabelValues(method).Inc()
+
+		t := time.NewTimer(h.delay(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// streamInterceptor puts streams behind the breaker and gives every message
+// callTimeout to arrive, so a stalled stream fails instead of hanging; the
+// whole stream may take longer. Streams are not retried, since the caller
+// may already have used part of the response.
+func (h *historyCaller) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if strings.HasPrefix(method, historyHealthPrefix) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	if err := h.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &historyStream{timeout: h.callTimeout, breaker: h.breaker, cancel: cancel}
+	s.timer = time.AfterFunc(h.callTimeout, s.expire)
+	// A stream the caller abandons still ends the breaker's probe.
+	context.AfterFunc(ctx, func() { s.finish(status.FromContextError(ctx.Err()).Err()) })
+
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		s.finish(err)
+		return nil, s.translate(err)
+	}
+	s.timer.Stop()
+	s.ClientStream = cs
+	return s, nil
+}
+
+// historyStream is a stream opened by streamInterceptor. Its outcome is
+// recorded with the breaker once: at the end of the stream, on the first
+// error, or when the caller's context ends.
+type historyStream struct {
+	grpc.ClientStream
+	timeout time.Duration
+	breaker *circuitBreaker
+	cancel  context.CancelFunc
+	timer   *time.Timer
+
+	expired atomic.Bool
+	once    sync.Once
+}
+
+var errStreamTimeout = status.Error(codes.DeadlineExceeded, "LocationHistory stream timed out")
+
+func (s *historyStream) expire() {
+	s.expired.Store(true)
+	s.finish(errStreamTimeout)
+}
+
+func (s *historyStream) finish(err error) {
+	s.once.Do(func() {
+		s.timer.Stop()
+		s.breaker.record(err)
+		s.cancel()
+	})
+}
+
+// translate reports a stream canceled by expire as timed out.
+func (s *historyStream) translate(err error) error {
+	if s.expired.Load() {
+		return errStreamTimeout
+	}
+	return err
+}
+
+func (s *historyStream) RecvMsg(m interface{}) error {
+	s.timer.Reset(s.timeout)
+	err := s.ClientStream.RecvMsg(m)
+	s.timer.Stop()
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		err = s.translate(err)
+		s.finish(err)
+	}
+	return err
+}
+
+// circuitBreaker opens after threshold consecutive failures and then fails
+// calls fast for cooldown. Once the cooldown has passed a single probe call
+// is let through: success closes the breaker, failure reopens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+var errBreakerOpen = status.Error(codes.Unavailable, "LocationHistory service is unavailable, circuit breaker is open")
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return errBreakerOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// failure reports whether err suggests history itself is unhealthy, as
+// opposed to rejecting this particular request.
+func failure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if status.Code(err) == codes.Canceled {
+		// The caller gave up; that says nothing about history.
+		return
+	}
+	if !failure(err) {
+		b.failures = 0
+		if !b.openedAt.IsZero() {
+			b.openedAt = time.Time{}
+			historyBreakerOpen.Set(0)
+		}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold || !b.openedAt.IsZero() {
+		b.openedAt = time.Now()
+		historyBreakerOpen.Set(1)
+	}
+}
+
+// retryAfter is how long until the breaker lets a probe through, or zero
+// when it is closed.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+// historyFailure responds to a failed LocationHistory call: 503 with a
+// Retry-After hint while history is unavailable, 504 when it timed out and
+// fallback otherwise.
+func historyFailure(c *gin.Context, err error, fallback int, message string) {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		wait := time.Second
+		if historyCalls != nil {
+			wait = max(historyCalls.breaker.retryAfter(), wait)
+		}
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "LocationHistory service is unavailable"})
+	case codes.DeadlineExceeded:
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "LocationHistory service timed out"})
+	default:
+		c.JSON(fallback, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestHistoryCaller(threshold int, cooldown time.Duration) *historyCaller {
+	return newHistoryCaller(config.HistoryClient{
+		CallTimeout:      config.Duration(50 * time.Millisecond),
+		MaxAttempts:      3,
+		RetryBackoff:     config.Duration(time.Millisecond),
+		MaxRetryBackoff:  config.Duration(2 * time.Millisecond),
+		BreakerThreshold: threshold,
+		BreakerCooldown:  config.Duration(cooldown),
+	})
+}
+
+// failingInvoker fails with the given codes in order, then succeeds.
+func failingInvoker(calls *int, failures ...codes.Code) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= len(failures) {
+			return status.Error(failures[*calls-1], "failed")
+		}
+		return nil
+	}
+}
+
+func callHistory(h *historyCaller, ctx context.Context, invoker grpc.UnaryInvoker) error {
+	return h.unaryInterceptor(ctx, "/location.LocationService/UpdateLocation", nil, nil, nil, invoker)
+}
+
+func TestHistoryCallerRetries(t *testing.T) {
+	h := newTestHistoryCaller(10, time.Minute)
+
+	// Transient failures are retried
+	calls := 0
+	err := callHistory(h, context.Background(), failingInvoker(&calls, codes.Unavailable, codes.Unavailable))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Up to MaxAttempts
+	calls = 0
+	err = callHistory(h, context.Background(), failingInvoker(&calls, codes.Unavailable, codes.Unavailable, codes.Unavailable))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, calls)
+
+	// Anything else is returned at once
+	calls = 0
+	err = callHistory(h, context.Background(), failingInvoker(&calls, codes.InvalidArgument))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, 1, calls)
+
+	// Each attempt gets a deadline
+	err = callHistory(h, context.Background(), func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// No retries once the caller has gone
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = callHistory(h, ctx, failingInvoker(&calls, codes.Unavailable, codes.Unavailable))
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assert.Equal(t, 1, calls)
+}
+
+// fakeStream sends n messages, each after delay, then ends.
+type fakeStream struct {
+	grpc.ClientStream
+	ctx   context.Context
+	n     int
+	delay time.Duration
+}
+
+func (f *fakeStream) RecvMsg(m interface{}) error {
+	if f.n == 0 {
+		return io.EOF
+	}
+	select {
+	case <-time.After(f.delay):
+		f.n--
+		return nil
+	case <-f.ctx.Done():
+		return status.FromContextError(f.ctx.Err()).Err()
+	}
+}
+
+func openHistoryStream(h *historyCaller, n int, delay time.Duration) (grpc.ClientStream, error) {
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeStream{ctx: ctx, n: n, delay: delay}, nil
+	}
+	return h.streamInterceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/location.LocationService/ExportUser", streamer)
+}
+
+func TestHistoryCallerStreams(t *testing.T) {
+	h := newTestHistoryCaller(1, time.Minute)
+
+	// Each message gets the call timeout, not the whole stream
+	s, err := openHistoryStream(h, 3, 30*time.Millisecond)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.RecvMsg(nil))
+	}
+	assert.Equal(t, io.EOF, s.RecvMsg(nil))
+
+	// A stalled stream times out and opens the breaker
+	s, err = openHistoryStream(h, 1, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(s.RecvMsg(nil)))
+	_, err = openHistoryStream(h, 1, 0)
+	assert.Equal(t, errBreakerOpen, err)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	assert.NoError(t, b.allow())
+	b.record(unavailable)
+	assert.NoError(t, b.allow())
+	b.record(unavailable)
+
+	// Open: calls fail fast
+	assert.Equal(t, errBreakerOpen, b.allow())
+	assert.Greater(t, b.retryAfter(), time.Duration(0))
+
+	// After the cooldown a single probe goes through
+	time.Sleep(25 * time.Millisecond)
+	assert.NoError(t, b.allow())
+	assert.Equal(t, errBreakerOpen, b.allow())
+
+	// A failed probe reopens the breaker
+	b.record(unavailable)
+	assert.Equal(t, errBreakerOpen, b.allow())
+
+	// A successful probe closes it
+	time.Sleep(25 * time.Millisecond)
+	assert.NoError(t, b.allow())
+	b.record(nil)
+	assert.NoError(t, b.allow())
+	assert.Equal(t, time.Duration(0), b.retryAfter())
+}
+
+func TestHistoryFailure(t *testing.T) {
+	r := gin.New()
+	r.GET("/:code", func(c *gin.Context) {
+		code := codes.Internal
+		_ = code.UnmarshalJSON([]byte(`"` + c.Param("code") + `"`))
+		historyFailure(c, status.Error(code, "failed"), http.StatusBadGateway, "Failed")
+	})
+
+	for code, want := range map[string]int{
+		"UNAVAILABLE":       http.StatusServiceUnavailable,
+		"DEADLINE_EXCEEDED": http.StatusGatewayTimeout,
+		"INTERNAL":          http.StatusBadGateway,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/"+code, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, code)
+		if want == http.StatusServiceUnavailable {
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+		}
+	}
+}
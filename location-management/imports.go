@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	importBatchSize    = 500
+	importSpoolPattern = "import-*.json"
+	// importMaxErrors bounds the per-element errors kept on a job; later
+	// ones are only counted as skipped.
+	importMaxErrors = 100
+)
+
+const (
+	importPending   = "pending"
+	importRunning   = "running"
+	importSucceeded = "succeeded"
+	importFailed    = "failed"
+)
+
+var (
+	errImportRunning = errors.New("user already has an import running")
+	errImportsBusy   = errors.New("too many imports running")
+)
+
+// imports runs uploaded imports. main replaces it with one built from the
+// imports config.
+var imports = newImporter(config.DefaultManagement().Imports)
+
+// importJob is an import and its progress. Points are counted as inserted,
+// duplicates of stored locations, or skipped when invalid.
+type importJob struct {
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Status     string     `json:"status"`
+	Format     string     `json:"format,omitempty"`
+	BytesTotal int64      `json:"bytes_total"`
+	BytesRead  int64      `json:"bytes_read"`
+	PointsRead int64      `json:"points_read"`
+	Inserted   int64      `json:"inserted"`
+	Duplicates int64      `json:"duplicates"`
+	Skipped    int64      `json:"skipped"`
+	Errors     []string   `json:"errors"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// skip records an element or point that could not be imported.
+func (j *importJob) skip(path string, err error) {
+	j.Skipped++
+	if len(j.Errors) < importMaxErrors {
+		j.Errors = append(j.Errors, path+": "+err.Error())
+	}
+}
+
+func ensureImportJobsTable() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS import_jobs (
+            id BIGSERIAL PRIMARY KEY,
+            username TEXT NOT NULL,
+            status TEXT NOT NULL DEFAULT 'pending',
+            format TEXT NOT NULL DEFAULT '',
+            bytes_total BIGINT NOT NULL DEFAULT 0,
+            bytes_read BIGINT NOT NULL DEFAULT 0,
+            points_read BIGINT NOT NULL DEFAULT 0,
+            inserted BIGINT NOT NULL DEFAULT 0,
+            duplicates BIGINT NOT NULL DEFAULT 0,
+            skipped BIGINT NOT NULL DEFAULT 0,
+            errors TEXT NOT NULL DEFAULT '[]',
+            error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            finished_at TIMESTAMP
+        )`)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec("CREATE INDEX IF NOT EXISTS import_jobs_username_idx ON import_jobs (username, id)")
+	return err
+}
+
+// failInterruptedImports fails the jobs a previous process left pending or
+// running, which nothing will finish, and removes their spooled uploads
+// from dir.
+func failInterruptedImports(dir string) error {
+	res, err := db.DB.Exec(`
+        UPDATE import_jobs
+        SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP
+        WHERE status IN ($3, $4)`,
+		importFailed, "Interrupted by a restart; upload the file again to resume", importPending, importRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Warn("Failed interrupted imports", "jobs", n)
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, importSpoolPattern))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to remove spooled import", "path", path, "error", err)
+		}
+	}
+	return nil
+}
+
+const importJobColumns = "id, username, status, format, bytes_total, bytes_read, points_read, inserted, duplicates, skipped, errors, error, created_at, finished_at"
+
+func scanImportJob(row rowScanner) (*importJob, error) {
+	var j importJob
+	var errs string
+	var finishedAt sql.NullTime
+	if err := row.Scan(&j.ID, &j.Username, &j.Status, &j.Format, &j.BytesTotal, &j.BytesRead, &j.PointsRead,
+		&j.Inserted, &j.Duplicates, &j.Skipped, &errs, &j.Error, &j.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(errs), &j.Errors); err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+// saveImportJob stores the progress of j.
+func saveImportJob(ctx context.Context, j *importJob) error {
+	errs, err := json.Marshal(j.Errors)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.ExecContext(ctx, `
+        UPDATE import_jobs
+        SET status = $2, format = $3, bytes_read = $4, points_read = $5, inserted = $6,
+            duplicates = $7, skipped = $8, errors = $9, error = $10, finished_at = $11
+        WHERE id = $1`,
+		j.ID, j.Status, j.Format, j.BytesRead, j.PointsRead, j.Inserted,
+		j.Duplicates, j.Skipped, string(errs), j.Error, j.FinishedAt)
+	return err
+}
+
+// importer runs import jobs in the background, one goroutine per job, and
+// bulk-loads their points through LocationHistory. It runs at most maxJobs
+// jobs at once and one per user.
+type importer struct {
+	dir      string
+	maxBytes int64
+	maxJobs  int
+	send     func(context.Context, *pb.ImportLocationsRequest) (*pb.ImportLocationsResponse, error)
+	save     func(context.Context, *importJob) error
+
+	mu     sync.Mutex
+	active map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newImporter(cfg config.Imports) *importer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &importer{
+		dir:      cfg.Dir,
+		maxBytes: int64(cfg.MaxMB) << 20,
+		maxJobs:  cfg.MaxJobs,
+		active:   map[string]bool{},
+		send: func(ctx context.Context, req *pb.ImportLocationsRequest) (*pb.ImportLocationsResponse, error) {
+			return locationHistoryClient.ImportLocations(ctx, req)
+		},
+		save:   saveImportJob,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// reserve claims a job slot for username, to be given back with release.
+func (im *importer) reserve(username string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.active[username] {
+		return errImportRunning
+	}
+	if len(im.active) >= im.maxJobs {
+		return errImportsBusy
+	}
+	im.active[username] = true
+	return nil
+}
+
+func (im *importer) release(username string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	delete(im.active, username)
+}
+
+// shutdown stops running jobs, which are marked failed, and waits for them.
+func (im *importer) shutdown() {
+	im.cancel()
+	im.wg.Wait()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// process reads a Takeout export from r and imports it for j.Username,
+// saving the job after every batch. Elements and points that cannot be
+// imported are skipped; it returns an error when the file cannot be read or
+// a batch fails.
+func (im *importer) process(ctx context.Context, j *importJob, r io.Reader) error {
+	cr := &countingReader{r: r}
+	batch := make([]*pb.ImportedLocation, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		resp, err := im.send(ctx, &pb.ImportLocationsRequest{Username: j.Username, Locations: batch})
+		if err != nil {
+			return fmt.Errorf("failed to store locations: %w", err)
+		}
+		batch = make([]*pb.ImportedLocation, 0, importBatchSize)
+		j.Inserted += resp.GetInserted()
+		j.Duplicates += resp.GetDuplicates()
+		j.BytesRead = cr.n
+		return im.save(ctx, j)
+	}
+
+	format, err := readTakeout(cr, func(it takeoutItem) error {
+		if it.Err != nil {
+			j.skip(it.Path, it.Err)
+			return nil
+		}
+		for _, p := range it.Points {
+			j.PointsRead++
+			req := &pb.ImportLocationsRequest{Username: j.Username, Locations: []*pb.ImportedLocation{p}}
+			if err := req.Validate(); err != nil {
+				j.skip(it.Path, err)
+				continue
+			}
+			batch = append(batch, p)
+			if len(batch) == importBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	j.Format = format
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	j.BytesRead = cr.n
+	return nil
+}
+
+// run imports the spooled file at path for j and removes it.
+func (im *importer) run(j *importJob, path string) {
+	defer im.wg.Done()
+	defer im.release(j.Username)
+	defer os.Remove(path)
+
+	j.Status = importRunning
+	if err := im.save(im.ctx, j); err != nil {
+		slog.Error("Failed to update import job", "job", j.ID, "error", err)
+	}
+
+	err := func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return im.process(im.ctx, j, f)
+	}()
+
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Status = importSucceeded
+	switch {
+	case im.ctx.Err() != nil:
+		j.Status, j.Error = importFailed, "Interrupted by shutdown; upload the file again to resume"
+	case errors.Is(err, errTakeoutFormat):
+		j.Status, j.Error = importFailed, "File is not a Google Takeout Records.json or Semantic Location History export"
+	case err != nil:
+		j.Status, j.Error = importFailed, err.Error()
+	}
+	// The job context is canceled on shutdown, but the outcome is still
+	// recorded.
+	if err := im.save(context.WithoutCancel(im.ctx), j); err != nil {
+		slog.Error("Failed to update import job", "job", j.ID, "error", err)
+	}
+	slog.Info("Import finished", "job", j.ID, "username", j.Username, "status", j.Status,
+		"inserted", j.Inserted, "duplicates", j.Duplicates, "skipped", j.Skipped)
+}
+
+// spool copies the upload to a temporary file so the request can finish
+// while the job runs.
+func (im *importer) spool(c *gin.Context) (string, int64, error) {
+	f, err := os.CreateTemp(im.dir, importSpoolPattern)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, http.MaxBytesReader(c.Writer, c.Request.Body, im.maxBytes))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
+
+// createImport handles POST /v1/users/:username/imports.
+// Requires the user or the admin scope. The body is a Google Takeout
+// Records.json or monthly Semantic Location History file; the import runs
+// in the background and is returned with 202 for polling. A user gets 409
+// while one of their imports runs, and everyone gets 429 while the importer
+// is full.
+func (im *importer) createImport(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	// The slot is taken before the upload is spooled, so a refused
+	// request does not fill the disk first.
+	switch err := im.reserve(username); {
+	case errors.Is(err, errImportRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": "An import is already running for this user"})
+		return
+	case errors.Is(err, errImportsBusy):
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many imports are running; try again later"})
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			im.release(username)
+		}
+	}()
+
+	path, size, err := im.spool(c)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Import files may be at most %d MB", im.maxBytes>>20)})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to spool import", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload"})
+		return
+	}
+	if size == 0 {
+		os.Remove(path)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is empty"})
+		return
+	}
+
+	row := db.DB.QueryRowContext(c.Request.Context(), `
+        INSERT INTO import_jobs (username, bytes_total)
+        VALUES ($1, $2)
+        RETURNING `+importJobColumns,
+		username, size)
+	j, err := scanImportJob(row)
+	if err != nil {
+		os.Remove(path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+
+	started = true
+	im.wg.Add(1)
+	go im.run(j, path)
+
+	c.Header("Location", fmt.Sprintf("%s/%d", c.Request.URL.Path, j.ID))
+	c.JSON(http.StatusAccepted, j)
+}
+
+// listImports handles GET /v1/users/:username/imports.
+// Requires the user or the admin scope. Returns the user's imports, newest
+// first.
+func listImports(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	rows, err := db.DB.QueryContext(c.Request.Context(), `
+        SELECT `+importJobColumns+`
+        FROM import_jobs
+        WHERE username = $1
+        ORDER BY id DESC`,
+		username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	defer rows.Close()
+
+	jobs := []*importJob{}
+	for rows.Next() {
+		j, err := scanImportJob(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read import jobs"})
+			return
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read import jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imports": jobs})
+}
+
+// getImport handles GET /v1/users/:username/imports/:id.
+// Requires the user or the admin scope.
+func getImport(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+		return
+	}
+
+	row := db.DB.QueryRowContext(c.Request.Context(), `
+        SELECT `+importJobColumns+`
+        FROM import_jobs
+        WHERE id = $1 AND username = $2`,
+		id, username)
+	j, err := scanImportJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
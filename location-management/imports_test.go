@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeImportHistory stores imported locations by timestamp, as
+// LocationHistory dedupes them.
+type fakeImportHistory struct {
+	stored  map[int64]bool
+	batches int
+	fail    error
+}
+
+func (f *fakeImportHistory) send(_ context.Context, req *pb.ImportLocationsRequest) (*pb.ImportLocationsResponse, error) {
+	if f.fail != nil {
+		return nil, f.fail
+	}
+	f.batches++
+	resp := &pb.ImportLocationsResponse{}
+	for _, l := range req.GetLocations() {
+		if f.stored[l.GetTimestamp()] {
+			resp.Duplicates++
+			continue
+		}
+		f.stored[l.GetTimestamp()] = true
+		resp.Inserted++
+	}
+	return resp, nil
+}
+
+func takeoutRecordsFile(n int) string {
+	records := make([]string, n)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"latitudeE7": 444268000, "longitudeE7": 261025000, "timestampMs": "%d"}`, (1617188765+int64(i))*1000)
+	}
+	return `{"locations": [` + strings.Join(records, ",") + `]}`
+}
+
+func TestImportProcess(t *testing.T) {
+	history := &fakeImportHistory{stored: map[int64]bool{1617188765: true}}
+	saves := 0
+	im := &importer{
+		send: history.send,
+		save: func(context.Context, *importJob) error { saves++; return nil },
+	}
+
+	data := takeoutRecordsFile(importBatchSize + 1)
+	data = strings.Replace(data, `{"locations": [`, `{"locations": [{"latitudeE7": 950000000, "longitudeE7": 0, "timestamp": "2021-03-31T11:06:05Z"}, "oops", `, 1)
+	j := &importJob{Username: "testuser"}
+	assert.NoError(t, im.process(context.Background(), j, strings.NewReader(data)))
+
+	assert.Equal(t, takeoutRecords, j.Format)
+	assert.Equal(t, int64(len(data)), j.BytesRead)
+	assert.Equal(t, int64(importBatchSize+2), j.PointsRead)
+	assert.Equal(t, int64(importBatchSize), j.Inserted)
+	assert.Equal(t, int64(1), j.Duplicates)
+	assert.Equal(t, int64(2), j.Skipped)
+	assert.Len(t, j.Errors, 2)
+	assert.Contains(t, j.Errors[0], "locations[0]: ")
+	assert.Contains(t, j.Errors[1], "locations[1]: ")
+	assert.Equal(t, 2, history.batches)
+	assert.Equal(t, 2, saves)
+
+	// Importing the file again only finds duplicates
+	j = &importJob{Username: "testuser"}
+	assert.NoError(t, im.process(context.Background(), j, strings.NewReader(data)))
+	assert.Equal(t, int64(0), j.Inserted)
+	assert.Equal(t, int64(importBatchSize+1), j.Duplicates)
+}
+
+func TestImportProcessFailures(t *testing.T) {
+	history := &fakeImportHistory{stored: map[int64]bool{}, fail: errors.New("unavailable")}
+	im := &importer{
+		send: history.send,
+		save: func(context.Context, *importJob) error { return nil },
+	}
+
+	j := &importJob{Username: "testuser"}
+	err := im.process(context.Background(), j, strings.NewReader(takeoutRecordsFile(3)))
+	assert.ErrorContains(t, err, "failed to store locations")
+
+	j = &importJob{Username: "testuser"}
+	err = im.process(context.Background(), j, strings.NewReader(`{"trips": []}`))
+	assert.ErrorIs(t, err, errTakeoutFormat)
+}
+
+func TestImportJobErrorsCapped(t *testing.T) {
+	j := &importJob{}
+	for i := 0; i < importMaxErrors+5; i++ {
+		j.skip(fmt.Sprintf("locations[%d]", i), errors.New("missing coordinates"))
+	}
+	assert.Equal(t, int64(importMaxErrors+5), j.Skipped)
+	assert.Len(t, j.Errors, importMaxErrors)
+}
+
+func TestImportSlots(t *testing.T) {
+	im := newImporter(config.Imports{MaxMB: 1, MaxJobs: 2})
+	assert.NoError(t, im.reserve("alice"))
+	assert.ErrorIs(t, im.reserve("alice"), errImportRunning)
+	assert.NoError(t, im.reserve("bob"))
+	assert.ErrorIs(t, im.reserve("carol"), errImportsBusy)
+
+	im.release("alice")
+	assert.NoError(t, im.reserve("carol"))
+	assert.ErrorIs(t, im.reserve("alice"), errImportsBusy)
+}
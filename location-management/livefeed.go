@@ -0,0 +1,507 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	liveWriteTimeout    = 10 * time.Second
+	livePingPeriod      = 30 * time.Second
+	livePongTimeout     = 60 * time.Second
+	liveContactsRefresh = time.Minute
+	liveMaxMessageBytes = 4096
+	liveMaxUsernames    = 100
+)
+
+// liveFeed serves the live map. main replaces it with one built from the
+// live config.
+var liveFeed = newLiveHub(config.DefaultManagement().Live)
+
+var liveUpgrader = websocket.Upgrader{
+	// Connections authenticate with a token, never a cookie, so another
+	// origin cannot use a user's session.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// livePosition is one user's position as shown to a subscriber.
+type livePosition struct {
+	// ID is the user_locations row, the event ID of user streams.
+	ID        int64   `json:"-"`
+	Username  string  `json:"username"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// liveRequest is a message from the client. Each subscribe replaces the
+// connection's subscription: usernames when given, otherwise bbox.
+type liveRequest struct {
+	Type      string   `json:"type"`
+	BBox      liveBBox `json:"bbox"`
+	Usernames []string `json:"usernames"`
+}
+
+type liveBBox struct {
+	MinLatitude  *float64 `json:"min_latitude"`
+	MinLongitude *float64 `json:"min_longitude"`
+	MaxLatitude  *float64 `json:"max_latitude"`
+	MaxLongitude *float64 `json:"max_longitude"`
+}
+
+// liveMessage is a message to the client.
+type liveMessage struct {
+	Type      string            `json:"type"`
+	Positions []livePosition    `json:"positions,omitempty"`
+	Removed   []string          `json:"removed,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Fields    validation.Errors `json:"fields,omitempty"`
+}
+
+// liveSubscription selects the positions a connection receives.
+type liveSubscription struct {
+	usernames                      map[string]bool
+	minLat, minLon, maxLat, maxLon float64
+}
+
+func (r liveRequest) subscription() (*liveSubscription, error) {
+	var v validation.Validator
+	v.OneOf("type", r.Type, "subscribe")
+	if len(r.Usernames) > 0 {
+		v.AtMost("usernames", int64(len(r.Usernames)), liveMaxUsernames)
+		sub := &liveSubscription{usernames: make(map[string]bool)}
+		for _, u := range r.Usernames {
+			v.Username("usernames", u)
+			sub.usernames[u] = true
+		}
+		return sub, v.Err()
+	}
+
+	v.Latitude("bbox.min_latitude", r.BBox.MinLatitude)
+	v.Longitude("bbox.min_longitude", r.BBox.MinLongitude)
+	v.Latitude("bbox.max_latitude", r.BBox.MaxLatitude)
+	v.Longitude("bbox.max_longitude", r.BBox.MaxLongitude)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return &liveSubscription{
+		minLat: min(*r.BBox.MinLatitude, *r.BBox.MaxLatitude),
+		maxLat: max(*r.BBox.MinLatitude, *r.BBox.MaxLatitude),
+		minLon: *r.BBox.MinLongitude,
+		maxLon: *r.BBox.MaxLongitude,
+	}, nil
+}
+
+// matches reports whether a position belongs to the subscription. A box
+// whose min_longitude is east of its max_longitude crosses the antimeridian.
+func (s *liveSubscription) matches(p livePosition) bool {
+	if s.usernames != nil {
+		return s.usernames[p.Username]
+	}
+	if p.Latitude < s.minLat || p.Latitude > s.maxLat {
+		return false
+	}
+	if s.minLon <= s.maxLon {
+		return p.Longitude >= s.minLon && p.Longitude <= s.maxLon
+	}
+	return p.Longitude >= s.minLon || p.Longitude <= s.maxLon
+}
+
+// liveSettings are the settings of the user whose position changed.
+type liveSettings struct {
+	visibility string
+	privacy    privacySetting
+}
+
+func getLiveSettings(ctx context.Context, username string) (liveSettings, error) {
+	s := liveSettings{visibility: visibilityPublic, privacy: privacySetting{Mode: precisionExact}}
+	err := db.DB.QueryRowContext(ctx, "SELECT visibility, precision_mode, precision_meters FROM user_settings WHERE username = $1",
+		username).Scan(&s.visibility, &s.privacy.Mode, &s.privacy.Meters)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
+
+func acceptedContacts(ctx context.Context, username string) (map[string]bool, error) {
+	contacts, err := listContacts(ctx, db.DB, username)
+	if err != nil {
+		return nil, err
+	}
+	accepted := make(map[string]bool)
+	for _, ct := range contacts {
+		if ct.Status == "accepted" {
+			accepted[ct.Username] = true
+		}
+	}
+	return accepted, nil
+}
+
+// liveSubscriber is one live map connection or user stream. conn is only
+// set for WebSocket connections.
+type liveSubscriber struct {
+	viewer string
+	conn   *websocket.Conn
+	ready  chan struct{}
+	// stop ends the connection, guarded by liveHub.mu.
+	stop func()
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	sub      *liveSubscription
+	contacts map[string]bool
+	pending  map[string]livePosition
+	removed  map[string]bool
+	shown    map[string]bool
+}
+
+func newLiveSubscriber(viewer string) *liveSubscriber {
+	return &liveSubscriber{
+		viewer:  viewer,
+		ready:   make(chan struct{}, 1),
+		pending: make(map[string]livePosition),
+		removed: make(map[string]bool),
+		shown:   make(map[string]bool),
+	}
+}
+
+// canSee applies the same visibility rules as search.
+func (s *liveSubscriber) canSee(username, visibility string) bool {
+	switch {
+	case username == s.viewer || visibility == visibilityPublic:
+		return true
+	case visibility == visibilityContacts:
+		return s.contacts[username]
+	}
+	return false
+}
+
+// offer queues p, coarsened by the user's privacy setting, when the viewer
+// may see the user and the public position matches the subscription. A user
+// shown earlier who no longer matches is queued as removed. Only the latest
+// position of each user is kept until the next write, so throttling drops
+// intermediate positions rather than delaying them.
+func (s *liveSubscriber) offer(p livePosition, settings liveSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sub == nil {
+		return
+	}
+	p.Latitude, p.Longitude = publicLocation(s.viewer, p.Username, p.Latitude, p.Longitude, settings.privacy)
+	switch {
+	case s.canSee(p.Username, settings.visibility) && s.sub.matches(p):
+		s.pending[p.Username] = p
+		delete(s.removed, p.Username)
+	case s.shown[p.Username]:
+		delete(s.pending, p.Username)
+		s.removed[p.Username] = true
+	default:
+		return
+	}
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe replaces the subscription and forgets what was queued or shown
+// for the previous one.
+func (s *liveSubscriber) subscribe(sub *liveSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sub = sub
+	clear(s.pending)
+	clear(s.removed)
+	clear(s.shown)
+}
+
+func (s *liveSubscriber) setContacts(contacts map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contacts = contacts
+}
+
+// take returns and clears the queued changes, sorted by username.
+func (s *liveSubscriber) take() *liveMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 && len(s.removed) == 0 {
+		return nil
+	}
+	msg := &liveMessage{Type: "positions"}
+	for username, p := range s.pending {
+		msg.Positions = append(msg.Positions, p)
+		s.shown[username] = true
+	}
+	for username := range s.removed {
+		msg.Removed = append(msg.Removed, username)
+		delete(s.shown, username)
+	}
+	slices.SortFunc(msg.Positions, func(a, b livePosition) int {
+		return cmp.Compare(a.Username, b.Username)
+	})
+	slices.Sort(msg.Removed)
+	clear(s.pending)
+	clear(s.removed)
+	return msg
+}
+
+func (s *liveSubscriber) write(msg *liveMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *liveSubscriber) writeControl(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteControl(messageType, data, time.Now().Add(liveWriteTimeout))
+}
+
+// liveHub fans accepted location updates out to the live map connections and
+// user streams of this instance.
+type liveHub struct {
+	interval       time.Duration
+	maxConnections int
+	// refresh is how often contacts and stream access are re-read.
+	refresh time.Duration
+	// contacts returns the accepted contacts of a viewer.
+	contacts func(ctx context.Context, viewer string) (map[string]bool, error)
+	// visible reports whether viewer may follow username's stream.
+	visible func(ctx context.Context, viewer, username string) (bool, error)
+
+	mu          sync.RWMutex
+	subscribers map[*liveSubscriber]struct{}
+}
+
+func newLiveHub(cfg config.Live) *liveHub {
+	return &liveHub{
+		interval:       time.Duration(cfg.Interval),
+		maxConnections: cfg.MaxConnections,
+		refresh:        liveContactsRefresh,
+		contacts:       acceptedContacts,
+		visible:        visibleTo,
+		subscribers:    make(map[*liveSubscriber]struct{}),
+	}
+}
+
+func (h *liveHub) add(s *liveSubscriber) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) >= h.maxConnections {
+		return false
+	}
+	h.subscribers[s] = struct{}{}
+	liveConnections.Set(float64(len(h.subscribers)))
+	return true
+}
+
+func (h *liveHub) remove(s *liveSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+	liveConnections.Set(float64(len(h.subscribers)))
+}
+
+// publish sends an accepted location update, stored as row id, to the
+// subscribers that may see it. The user's settings are only read while someone is connected, and a
+// failure to read them never fails the update itself.
+func (h *liveHub) publish(ctx context.Context, id int64, req *pb.LocationRequest) {
+	h.mu.RLock()
+	n := len(h.subscribers)
+	h.mu.RUnlock()
+	if n == 0 {
+		return
+	}
+
+	settings, err := getLiveSettings(ctx, req.GetUsername())
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read settings for the live map", "error", err)
+		return
+	}
+	h.broadcast(livePosition{
+		ID:        id,
+		Username:  req.GetUsername(),
+		Latitude:  req.GetLatitude(),
+		Longitude: req.GetLongitude(),
+		Timestamp: req.UnixTimestamp(),
+	}, settings)
+}
+
+func (h *liveHub) broadcast(p livePosition, settings liveSettings) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		s.offer(p, settings)
+	}
+}
+
+// shutdown ends every live connection so clients reconnect elsewhere.
+func (h *liveHub) shutdown() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		if s.stop != nil {
+			s.stop()
+		}
+	}
+}
+
+// setStop records how shutdown ends the connection of s.
+func (h *liveHub) setStop(s *liveSubscriber, stop func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.stop = stop
+}
+
+// serve handles GET /v1/live.
+// Requires any authenticated caller. Upgrades to a WebSocket on which the
+// client subscribes to a bounding box or a list of usernames and then
+// receives their positions as updates arrive, at most once per interval.
+func (h *liveHub) serve(c *gin.Context) {
+	p := currentPrincipal(c)
+	if p == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a WebSocket upgrade"})
+		return
+	}
+
+	s := newLiveSubscriber(p.Subject)
+	if !h.add(s) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many live connections"})
+		return
+	}
+	defer h.remove(s)
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	contacts, err := h.contacts(ctx, s.viewer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	s.setContacts(contacts)
+
+	conn, err := liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already responded.
+		return
+	}
+	defer conn.Close()
+	s.conn = conn
+	h.setStop(s, func() {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		_ = s.writeControl(websocket.CloseMessage, msg)
+		conn.Close()
+	})
+
+	go func() {
+		h.writeLoop(ctx, s)
+		// Unblock the read loop when writing fails.
+		conn.Close()
+	}()
+	h.readLoop(s)
+}
+
+// readLoop handles subscribe messages until the connection ends.
+func (h *liveHub) readLoop(s *liveSubscriber) {
+	s.conn.SetReadLimit(liveMaxMessageBytes)
+	s.conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req liveRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			if s.write(&liveMessage{Type: "error", Error: "Invalid message"}) != nil {
+				return
+			}
+			continue
+		}
+		sub, err := req.subscription()
+		if err != nil {
+			var errs validation.Errors
+			errors.As(err, &errs)
+			if s.write(&liveMessage{Type: "error", Error: err.Error(), Fields: errs}) != nil {
+				return
+			}
+			continue
+		}
+		s.subscribe(sub)
+		if s.write(&liveMessage{Type: "subscribed"}) != nil {
+			return
+		}
+	}
+}
+
+// writeLoop sends queued positions, waiting interval after each message,
+// keeps the connection alive with pings and refreshes the viewer's
+// contacts.
+func (h *liveHub) writeLoop(ctx context.Context, s *liveSubscriber) {
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	refresh := time.NewTicker(h.refresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if s.writeControl(websocket.PingMessage, nil) != nil {
+				return
+			}
+		case <-refresh.C:
+			contacts, err := h.contacts(ctx, s.viewer)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to refresh contacts for the live map", "error", err)
+				continue
+			}
+			s.setContacts(contacts)
+		case <-s.ready:
+			msg := s.take()
+			if msg == nil {
+				continue
+			}
+			if s.write(msg) != nil {
+				return
+			}
+			t := time.NewTimer(h.interval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+	}
+}
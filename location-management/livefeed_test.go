@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func TestLiveSubscription(t *testing.T) {
+	_, err := liveRequest{Type: "subscribe"}.subscription()
+	var errs validation.Errors
+	assert.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 4)
+
+	_, err = liveRequest{Type: "subscribe", Usernames: []string{"testuser", "ab"}}.subscription()
+	assert.ErrorContains(t, err, validation.MsgUsername)
+
+	_, err = liveRequest{Type: "subscribe", Usernames: make([]string, liveMaxUsernames+1)}.subscription()
+	assert.ErrorContains(t, err, "Invalid usernames. Must be at most 100")
+
+	sub, err := liveRequest{Type: "subscribe", Usernames: []string{"testuser"}}.subscription()
+	assert.NoError(t, err)
+	assert.True(t, sub.matches(livePosition{Username: "testuser"}))
+	assert.False(t, sub.matches(livePosition{Username: "otheruser"}))
+
+	sub, err = liveRequest{Type: "subscribe", BBox: liveBBox{
+		MinLatitude: ptr(10), MinLongitude: ptr(20), MaxLatitude: ptr(0), MaxLongitude: ptr(30),
+	}}.subscription()
+	assert.NoError(t, err)
+	assert.True(t, sub.matches(livePosition{Latitude: 5, Longitude: 25}))
+	assert.False(t, sub.matches(livePosition{Latitude: 5, Longitude: 35}))
+	assert.False(t, sub.matches(livePosition{Latitude: 11, Longitude: 25}))
+
+	// Crossing the antimeridian
+	sub, err = liveRequest{Type: "subscribe", BBox: liveBBox{
+		MinLatitude: ptr(-10), MinLongitude: ptr(170), MaxLatitude: ptr(10), MaxLongitude: ptr(-170),
+	}}.subscription()
+	assert.NoError(t, err)
+	assert.True(t, sub.matches(livePosition{Longitude: 175}))
+	assert.True(t, sub.matches(livePosition{Longitude: -175}))
+	assert.False(t, sub.matches(livePosition{Longitude: 0}))
+}
+
+func TestLiveSubscriberOffer(t *testing.T) {
+	s := newLiveSubscriber("testuser")
+	s.setContacts(map[string]bool{"friend1": true})
+	public := liveSettings{visibility: visibilityPublic, privacy: privacySetting{Mode: precisionExact}}
+
+	// Nothing is queued before the first subscribe
+	s.offer(livePosition{Username: "otheruser", Latitude: 1, Longitude: 1}, public)
+	assert.Nil(t, s.take())
+
+	sub, _ := liveRequest{Type: "subscribe", BBox: liveBBox{
+		MinLatitude: ptr(0), MinLongitude: ptr(0), MaxLatitude: ptr(10), MaxLongitude: ptr(10),
+	}}.subscription()
+	s.subscribe(sub)
+
+	s.offer(livePosition{Username: "otheruser", Latitude: 1, Longitude: 1, Timestamp: 1}, public)
+	s.offer(livePosition{Username: "otheruser", Latitude: 2, Longitude: 2, Timestamp: 2}, public)
+	s.offer(livePosition{Username: "friend1", Latitude: 3, Longitude: 3}, liveSettings{visibility: visibilityContacts})
+	s.offer(livePosition{Username: "stranger", Latitude: 3, Longitude: 3}, liveSettings{visibility: visibilityContacts})
+	s.offer(livePosition{Username: "hidden", Latitude: 3, Longitude: 3}, liveSettings{visibility: visibilityInvisible})
+	s.offer(livePosition{Username: "testuser", Latitude: 4, Longitude: 4}, liveSettings{visibility: visibilityInvisible})
+	s.offer(livePosition{Username: "faraway", Latitude: 50, Longitude: 50}, public)
+
+	// Only the latest position per visible user in the box
+	msg := s.take()
+	assert.Equal(t, []livePosition{
+		{Username: "friend1", Latitude: 3, Longitude: 3},
+		{Username: "otheruser", Latitude: 2, Longitude: 2, Timestamp: 2},
+		{Username: "testuser", Latitude: 4, Longitude: 4},
+	}, msg.Positions)
+	assert.Nil(t, s.take())
+
+	// Positions are coarsened like in search
+	grid := liveSettings{visibility: visibilityPublic, privacy: privacySetting{Mode: precisionGrid, Meters: 5000}}
+	s.offer(livePosition{Username: "otheruser", Latitude: 2.01, Longitude: 2.01}, grid)
+	msg = s.take()
+	lat, lon := fuzzLocation("otheruser", 2.01, 2.01, grid.privacy)
+	assert.Equal(t, []livePosition{{Username: "otheruser", Latitude: lat, Longitude: lon}}, msg.Positions)
+
+	// Leaving the box is reported once
+	s.offer(livePosition{Username: "otheruser", Latitude: 50, Longitude: 50}, public)
+	s.offer(livePosition{Username: "otheruser", Latitude: 51, Longitude: 51}, public)
+	msg = s.take()
+	assert.Empty(t, msg.Positions)
+	assert.Equal(t, []string{"otheruser"}, msg.Removed)
+	s.offer(livePosition{Username: "otheruser", Latitude: 52, Longitude: 52}, public)
+	assert.Nil(t, s.take())
+}
+
+func newTestLiveHub(interval time.Duration, maxConnections int) *liveHub {
+	h := newLiveHub(config.Live{Interval: config.Duration(interval), MaxConnections: maxConnections})
+	h.contacts = func(ctx context.Context, viewer string) (map[string]bool, error) {
+		return map[string]bool{}, nil
+	}
+	h.visible = func(ctx context.Context, viewer, username string) (bool, error) {
+		return true, nil
+	}
+	return h
+}
+
+func TestLiveFeed(t *testing.T) {
+	hub := newTestLiveHub(50*time.Millisecond, 1)
+	defer func(h *liveHub) { liveFeed = h }(liveFeed)
+	liveFeed = hub
+
+	auth := &authenticator{hmacSecret: []byte("test-secret"), apiKeys: newAPIKeyStore(1, 1)}
+	server := httptest.NewServer(newRouter(auth))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/live"
+
+	// Authentication happens on connect
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+signHS256(t, "test-secret", "testuser", ""), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// One connection at most
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?access_token="+signHS256(t, "test-secret", "otheruser", ""), nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	var msg liveMessage
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "subscribe", "usernames": []string{"ab"}}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "error", msg.Type)
+	assert.Equal(t, "usernames", msg.Fields[0].Field)
+
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "subscribe", "usernames": []string{"otheruser"}}))
+	msg = liveMessage{}
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "subscribed", msg.Type)
+
+	public := liveSettings{visibility: visibilityPublic, privacy: privacySetting{Mode: precisionExact}}
+	hub.broadcast(livePosition{Username: "otheruser", Latitude: 1, Longitude: 1, Timestamp: 1}, public)
+	msg = liveMessage{}
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, []livePosition{{Username: "otheruser", Latitude: 1, Longitude: 1, Timestamp: 1}}, msg.Positions)
+
+	// Updates within the interval are coalesced into the next message
+	start := time.Now()
+	hub.broadcast(livePosition{Username: "otheruser", Latitude: 2, Longitude: 2, Timestamp: 2}, public)
+	hub.broadcast(livePosition{Username: "otheruser", Latitude: 3, Longitude: 3, Timestamp: 3}, public)
+	msg = liveMessage{}
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, []livePosition{{Username: "otheruser", Latitude: 3, Longitude: 3, Timestamp: 3}}, msg.Positions)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	hub.shutdown()
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/gin-gonic/gin"
+)
+
+// liveResumeLimit bounds how many stored positions a resumed stream
+// replays.
+const liveResumeLimit = 100
+
+// ensureLocationIDs numbers user_locations rows. User streams use the IDs as
+// event IDs and resume after them. IDs are taken in insert order but rows
+// become visible in commit order, so a row can appear after one with a
+// higher ID; a stream resumed in between misses it.
+func ensureLocationIDs() error {
+	_, err := db.DB.Exec("ALTER TABLE user_locations ADD COLUMN IF NOT EXISTS id BIGSERIAL")
+	return err
+}
+
+func scanLivePositions(rows *sql.Rows, username string) ([]livePosition, error) {
+	defer rows.Close()
+	var positions []livePosition
+	for rows.Next() {
+		p := livePosition{Username: username}
+		var timestamp time.Time
+		if err := rows.Scan(&p.ID, &p.Latitude, &p.Longitude, &timestamp); err != nil {
+			return nil, err
+		}
+		p.Timestamp = timestamp.Unix()
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+// latestPosition returns the latest stored position of username, if any.
+func latestPosition(ctx context.Context, username string) ([]livePosition, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT id, latitude, longitude, timestamp
+        FROM user_locations
+        WHERE username = $1
+        ORDER BY timestamp DESC, id DESC
+        LIMIT 1`,
+		username)
+	if err != nil {
+		return nil, err
+	}
+	return scanLivePositions(rows, username)
+}
+
+// positionsAfter returns up to limit positions of username with IDs above
+// afterID, in ID order.
+func positionsAfter(ctx context.Context, username string, afterID int64, limit int) ([]livePosition, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT id, latitude, longitude, timestamp
+        FROM user_locations
+        WHERE username = $1 AND id > $2
+        ORDER BY id
+        LIMIT $3`,
+		username, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanLivePositions(rows, username)
+}
+
+// writeLiveEvent writes p as a server-sent event whose ID is its row.
+func writeLiveEvent(w io.Writer, p livePosition) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: position\ndata: %s\n\n", p.ID, data)
+	return err
+}
+
+// streamUser handles GET /v1/users/:username/live.
+// Requires a caller the user is visible to, as in search. Streams the user's
+// position as server-sent events: the latest stored position at once, then
+// every update, at most once per live interval. A Last-Event-ID header
+// replays up to 100 positions with higher IDs instead, in ID order.
+func (h *liveHub) streamUser(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	p := currentPrincipal(c)
+	if p == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	resume := c.GetHeader("Last-Event-ID")
+	var afterID int64
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		afterID = id
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	visible, err := h.visible(ctx, p.Subject, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	privacy, err := getPrivacySetting(ctx, db.DB, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	contacts, err := h.contacts(ctx, p.Subject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	s := newLiveSubscriber(p.Subject)
+	s.setContacts(contacts)
+	s.subscribe(&liveSubscription{usernames: map[string]bool{username: true}})
+	if !h.add(s) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many live connections"})
+		return
+	}
+	defer h.remove(s)
+	h.setStop(s, cancel)
+
+	// Subscribed before reading the backlog, so no update falls in between.
+	var backlog []livePosition
+	if resume != "" {
+		backlog, err = positionsAfter(ctx, username, afterID, liveResumeLimit)
+	} else {
+		backlog, err = latestPosition(ctx, username)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	replayed := make(map[int64]bool, len(backlog))
+	for _, pos := range backlog {
+		pos.Latitude, pos.Longitude = publicLocation(p.Subject, username, pos.Latitude, pos.Longitude, privacy)
+		if writeLiveEvent(c.Writer, pos) != nil {
+			return
+		}
+		replayed[pos.ID] = true
+	}
+	c.Writer.Flush()
+	h.streamUpdates(ctx, c.Writer, s, username, replayed)
+}
+
+// streamUpdates writes the positions of username queued for s until ctx
+// ends or the client goes away. Positions already replayed from the backlog
+// are skipped, and so are updates while the user is not visible to the viewer.
+// Updates are sent in the order they are published, which need not be ID
+// order.
+// Contacts are re-read periodically, and the stream ends once the viewer may
+// no longer follow the user.
+func (h *liveHub) streamUpdates(ctx context.Context, w gin.ResponseWriter, s *liveSubscriber, username string, replayed map[int64]bool) {
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	refresh := time.NewTicker(h.refresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			// Keeps proxies from closing an idle stream.
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-refresh.C:
+			visible, err := h.visible(ctx, s.viewer, username)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to refresh access to a live stream", "error", err)
+				continue
+			}
+			if !visible {
+				return
+			}
+			contacts, err := h.contacts(ctx, s.viewer)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to refresh contacts for a live stream", "error", err)
+				continue
+			}
+			s.setContacts(contacts)
+		case <-s.ready:
+			msg := s.take()
+			if msg == nil {
+				continue
+			}
+			for _, pos := range msg.Positions {
+				if replayed[pos.ID] {
+					continue
+				}
+				if writeLiveEvent(w, pos) != nil {
+					return
+				}
+			}
+			w.Flush()
+
+			t := time.NewTimer(h.interval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+	}
+}
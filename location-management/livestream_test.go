@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteLiveEvent(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeLiveEvent(&buf, livePosition{ID: 42, Username: "testuser", Latitude: 1.5, Longitude: 2.5, Timestamp: 1617188765}))
+	assert.Equal(t, "id: 42\nevent: position\n"+
+		`data: {"username":"testuser","latitude":1.5,"longitude":2.5,"timestamp":1617188765}`+"\n\n", buf.String())
+}
+
+func TestStreamUpdates(t *testing.T) {
+	hub := newTestLiveHub(time.Millisecond, 10)
+	s := newLiveSubscriber("testuser")
+	s.subscribe(&liveSubscription{usernames: map[string]bool{"otheruser": true}})
+	assert.True(t, hub.add(s))
+	defer hub.remove(s)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	hub.setStop(s, cancel)
+	done := make(chan struct{})
+	go func() {
+		hub.streamUpdates(ctx, c.Writer, s, "otheruser", map[int64]bool{3: true})
+		close(done)
+	}()
+
+	public := liveSettings{visibility: visibilityPublic, privacy: privacySetting{Mode: precisionExact}}
+	hidden := liveSettings{visibility: visibilityInvisible}
+	// Already replayed from the backlog
+	hub.broadcast(livePosition{ID: 3, Username: "otheruser", Timestamp: 3}, public)
+	time.Sleep(20 * time.Millisecond)
+	hub.broadcast(livePosition{ID: 6, Username: "otheruser", Timestamp: 6}, hidden)
+	time.Sleep(20 * time.Millisecond)
+	hub.broadcast(livePosition{ID: 7, Username: "otheruser", Latitude: 1, Longitude: 1, Timestamp: 7}, public)
+	time.Sleep(20 * time.Millisecond)
+	// Committed after a row with a higher ID
+	hub.broadcast(livePosition{ID: 5, Username: "otheruser", Latitude: 2, Longitude: 2, Timestamp: 5}, public)
+	time.Sleep(20 * time.Millisecond)
+
+	hub.shutdown()
+	<-done
+	assert.Equal(t, "id: 7\nevent: position\n"+
+		`data: {"username":"otheruser","latitude":1,"longitude":1,"timestamp":7}`+"\n\n"+
+		"id: 5\nevent: position\n"+
+		`data: {"username":"otheruser","latitude":2,"longitude":2,"timestamp":5}`+"\n\n", w.Body.String())
+}
+
+func TestStreamUpdatesRevoked(t *testing.T) {
+	hub := newTestLiveHub(time.Millisecond, 10)
+	hub.refresh = 5 * time.Millisecond
+	var removed, hidden atomic.Bool
+	hub.contacts = func(ctx context.Context, viewer string) (map[string]bool, error) {
+		return map[string]bool{"otheruser": !removed.Load()}, nil
+	}
+	hub.visible = func(ctx context.Context, viewer, username string) (bool, error) {
+		return !hidden.Load(), nil
+	}
+	s := newLiveSubscriber("testuser")
+	s.setContacts(map[string]bool{"otheruser": true})
+	s.subscribe(&liveSubscription{usernames: map[string]bool{"otheruser": true}})
+	assert.True(t, hub.add(s))
+	defer hub.remove(s)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	done := make(chan struct{})
+	go func() {
+		hub.streamUpdates(context.Background(), c.Writer, s, "otheruser", nil)
+		close(done)
+	}()
+
+	contacts := liveSettings{visibility: visibilityContacts, privacy: privacySetting{Mode: precisionExact}}
+	hub.broadcast(livePosition{ID: 1, Username: "otheruser", Timestamp: 1}, contacts)
+	time.Sleep(20 * time.Millisecond)
+	// Positions shared with contacts stop once the contact is removed
+	removed.Store(true)
+	time.Sleep(20 * time.Millisecond)
+	hub.broadcast(livePosition{ID: 2, Username: "otheruser", Timestamp: 2}, contacts)
+	time.Sleep(20 * time.Millisecond)
+
+	// and the stream ends once the user is no longer visible at all
+	hidden.Store(true)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream was not closed")
+	}
+	assert.Equal(t, "id: 1\nevent: position\n"+
+		`data: {"username":"otheruser","latitude":0,"longitude":0,"timestamp":1}`+"\n\n", w.Body.String())
+}
+
+func TestStreamUserErrors(t *testing.T) {
+	r := gin.New()
+	r.GET("/v1/users/:username/live", withPrincipal("testuser"), newTestLiveHub(time.Second, 1).streamUser)
+
+	for path, header := range map[string]string{
+		"/v1/users/ab/live":        "",
+		"/v1/users/otheruser/live": "yesterday",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if header != "" {
+			req.Header.Set("Last-Event-ID", header)
+		}
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
+
+func TestPositionsAfter(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+
+	db.DB = testDB
+	assert.NoError(t, ensureLocationIDs())
+	_, err := testDB.Exec("DELETE FROM user_locations WHERE username = $1", "streamuser")
+	assert.NoError(t, err)
+
+	// Four updates within the same second
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		var id int64
+		assert.NoError(t, testDB.QueryRow(`INSERT INTO user_locations (username, latitude, longitude, timestamp)
+            VALUES ($1, $2, $2, $3) RETURNING id`, "streamuser", float64(i), "2023-01-01T00:00:00Z").Scan(&id))
+		ids = append(ids, id)
+	}
+
+	latest, err := latestPosition(context.Background(), "streamuser")
+	assert.NoError(t, err)
+	assert.Len(t, latest, 1)
+	assert.Equal(t, ids[3], latest[0].ID)
+
+	// Resuming after the first event replays the next ones in order, even
+	// though they share its timestamp, and a limit keeps the oldest.
+	replay, err := positionsAfter(context.Background(), "streamuser", ids[0], 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []livePosition{
+		{ID: ids[1], Username: "streamuser", Latitude: 1, Longitude: 1, Timestamp: 1672531200},
+		{ID: ids[2], Username: "streamuser", Latitude: 2, Longitude: 2, Timestamp: 1672531200},
+	}, replay)
+
+	replay, err = positionsAfter(context.Background(), "streamuser", ids[3], liveResumeLimit)
+	assert.NoError(t, err)
+	assert.Empty(t, replay)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/telemetry"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var seenID string
+	r := gin.New()
+	r.Use(requestLogger(), recovery())
+	r.GET("/ping", func(c *gin.Context) {
+		seenID = telemetry.RequestID(c.Request.Context())
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	// A valid caller ID is kept and echoed
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set(telemetry.RequestIDHeader, "client-id-1")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "client-id-1", w.Header().Get(telemetry.RequestIDHeader))
+	assert.Equal(t, "client-id-1", seenID)
+
+	// An invalid one is replaced
+	w = httptest.NewRecorder()
+	req.Header.Set(telemetry.RequestIDHeader, "bad id")
+	r.ServeHTTP(w, req)
+	assert.NotEqual(t, "bad id", seenID)
+	assert.Equal(t, seenID, w.Header().Get(telemetry.RequestIDHeader))
+
+	// Panics become a 500 that still carries the ID
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/panic", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotEmpty(t, w.Header().Get(telemetry.RequestIDHeader))
+}
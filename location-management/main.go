@@ -2,141 +2,115 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"math"
 	"net/http"
-	"regexp"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/abotoiGrid/Golang-Project/config"
 	"github.com/abotoiGrid/Golang-Project/db"
 	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/telemetry"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 var locationHistoryClient pb.LocationServiceClient
 
-func initGRPCClient() {
-	conn, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+func initGRPCClient(cfg config.HistoryClient) *grpc.ClientConn {
+	opts, err := dialOptions(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to LocationHistory service: %v", err)
+		telemetry.Fatal("Failed to configure LocationHistory connection", err)
+	}
+	historyCalls = newHistoryCaller(cfg)
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(telemetry.UnaryClientInterceptor, countHistoryFailures, historyCalls.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(historyCalls.streamInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	conn, err := grpc.Dial(cfg.Target, opts...)
+	if err != nil {
+		telemetry.Fatal("Failed to connect to LocationHistory service", err)
 	}
 	locationHistoryClient = pb.NewLocationServiceClient(conn)
+	historyHealthClient = healthpb.NewHealthClient(conn)
+	return conn
 }
 
-func isValidUsername(username string) bool {
-	match, _ := regexp.MatchString(`^[a-zA-Z0-9]{4,16}$`, username)
-	return match
-}
-
-func isValidCoordinate(coordinate float64) bool {
-	return coordinate >= -180 && coordinate <= 180
-}
-
+// CalculateTravelDistance handles GET /users/distance.
+// Compatibility route for GET /v1/users/{username}/distance.
 func CalculateTravelDistance(c *gin.Context) {
 	var request struct {
-		Username string    `form:"username" binding:"required,alphanum,min=4,max=16"`
+		Username string    `form:"username"`
 		Start    time.Time `form:"start" time_format:"2006-01-02T15:04:05Z07:00"`
 		End      time.Time `form:"end" time_format:"2006-01-02T15:04:05Z07:00"`
 	}
 
 	if err := c.ShouldBindQuery(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username. Must be 4-16 alphanumeric characters"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time. Use RFC 3339, e.g. 2024-01-02T15:04:05Z"})
 		return
 	}
 
-	if !isValidUsername(request.Username) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid username. Must be 4-16 alphanumeric characters"})
-		return
+	req := &pb.TravelDistanceRequest{Username: request.Username}
+	if !request.Start.IsZero() {
+		req.Start = timestamppb.New(request.Start)
 	}
-
-	// Default to last 24 hours if no time range specified
-	if request.Start.IsZero() {
-		request.End = time.Now()
-		request.Start = request.End.Add(-24 * time.Hour)
+	if !request.End.IsZero() {
+		req.End = timestamppb.New(request.End)
 	}
-
-	rows, err := db.DB.Query(`
-        SELECT latitude, longitude, timestamp
-        FROM user_locations
-        WHERE username = $1 AND timestamp BETWEEN $2 AND $3
-        ORDER BY timestamp ASC`,
-		request.Username, request.Start, request.End)
-
+	resp, err := locationService.GetTravelDistance(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		respondRPCError(c, err)
 		return
 	}
-	defer rows.Close()
-
-	var totalDistance float64
-	var prevLat, prevLon float64
-	first := true
-
-	for rows.Next() {
-		var latitude, longitude float64
-		var timestamp time.Time
-		if err := rows.Scan(&latitude, &longitude, &timestamp); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan row"})
-			return
-		}
-
-		if !isValidCoordinate(latitude) || !isValidCoordinate(longitude) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coordinates"})
-			return
-		}
-
-		if !first {
-			totalDistance += CalculateDistance(prevLat, prevLon, latitude, longitude)
-		} else {
-			first = false
-		}
-
-		prevLat = latitude
-		prevLon = longitude
-	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"username": request.Username,
-		"distance": totalDistance,
-		"unit":     "kilometers",
-		"start":    request.Start,
-		"end":      request.End,
+		"username": resp.GetUsername(),
+		"distance": resp.GetDistance(),
+		"unit":     resp.GetUnit(),
+		"start":    resp.GetStart().AsTime(),
+		"end":      resp.GetEnd().AsTime(),
 	})
 }
 
+// UpdateLocation handles POST /location/update.
+// Compatibility route for POST /v1/locations.
 func UpdateLocation(c *gin.Context) {
 	var request struct {
-		Username  string  `json:"username" binding:"required,alphanum,min=4,max=16"`
-		Latitude  float64 `json:"latitude" binding:"required,gte=-90,lte=90"`
-		Longitude float64 `json:"longitude" binding:"required,gte=-180,lte=180"`
+		Username  string   `json:"username"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
+		locationUpdates.WithLabelValues(updateRejected).Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := db.DB.Exec("INSERT INTO user_locations (username, latitude, longitude) VALUES ($1, $2, $3)",
-		request.Username, request.Latitude, request.Longitude)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
-		return
-	}
-
-	timestamp := time.Now()
-	timestampStr := timestamp.Format(time.RFC3339)
-
-	_, err = locationHistoryClient.UpdateLocation(context.Background(), &pb.LocationRequest{
+	_, err := locationService.UpdateLocation(c.Request.Context(), &pb.LocationRequest{
 		Username:  request.Username,
 		Latitude:  request.Latitude,
 		Longitude: request.Longitude,
-		Timestamp: timestampStr,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed too communicate with LocationHistory service"})
+		respondRPCError(c, err)
 		return
 	}
 
@@ -158,13 +132,16 @@ func CalculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
+// searchUsers handles GET /users/search.
+// Compatibility route for GET /v1/users/search.
 func searchUsers(c *gin.Context) {
 	var request struct {
-		Latitude  float64 `form:"latitude" binding:"required,gte=-90,lte=90"`
-		Longitude float64 `form:"longitude" binding:"required,gte=-180,lte=180"`
-		Radius    float64 `form:"radius" binding:"required"`
-		Page      int     `form:"page,default=1"`
-		PageSize  int     `form:"page_size,default=10"`
+		Latitude  *float64 `form:"latitude"`
+		Longitude *float64 `form:"longitude"`
+		Radius    *float64 `form:"radius"`
+		Page      int32    `form:"page"`
+		PageSize  int32    `form:"page_size"`
+		Scope     string   `form:"scope"`
 	}
 
 	if err := c.ShouldBindQuery(&request); err != nil {
@@ -172,54 +149,313 @@ func searchUsers(c *gin.Context) {
 		return
 	}
 
-	offset := (request.Page - 1) * request.PageSize
-	rows, err := db.DB.Query(`
-        SELECT username, latitude, longitude 
-        FROM user_locations
-        WHERE earth_box(ll_to_earth($1, $2), $3) @> ll_to_earth(latitude, longitude)
-        LIMIT $4 OFFSET $5`,
-		request.Latitude, request.Longitude, request.Radius*1000, request.PageSize, offset)
-
+	resp, err := locationService.SearchUsers(c.Request.Context(), &pb.SearchUsersRequest{
+		Latitude:  request.Latitude,
+		Longitude: request.Longitude,
+		Radius:    request.Radius,
+		Page:      request.Page,
+		PageSize:  request.PageSize,
+		Scope:     request.Scope,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		respondRPCError(c, err)
 		return
 	}
-	defer rows.Close()
 
 	var results []map[string]interface{}
+	for _, u := range resp.GetUsers() {
+		results = append(results, map[string]interface{}{
+			"username":  u.GetUsername(),
+			"latitude":  u.GetLatitude(),
+			"longitude": u.GetLongitude(),
+			"distance":  u.GetDistance(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": results,
+		"total": resp.GetTotal(),
+	})
+}
+
+// nearbyUser is the public position of a user near a search origin.
+type nearbyUser struct {
+	Username  string  `json:"username"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Kilometers from the origin to the public position.
+	Distance float64 `json:"distance"`
+}
+
+// nearbyUsers returns the latest position of each user visible to viewer
+// whose stored position is within boxMeters of the origin, or of every such
+// user when boxMeters is 0, ordered by username. Positions are coarsened
+// before distances are computed, so callers must rank and filter on the
+// result and widen boxMeters by maxPrecisionMeters.
+func nearbyUsers(ctx context.Context, viewer, scope string, originLat, originLon, boxMeters float64) ([]nearbyUser, error) {
+	args := []interface{}{originLat, originLon, viewer}
+	box := ""
+	if boxMeters > 0 {
+		args = append(args, boxMeters)
+		box = "earth_box(ll_to_earth($1, $2), $4) @> ll_to_earth(l.latitude, l.longitude) AND "
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT l.username, l.latitude, l.longitude,
+               COALESCE(s.precision_mode, 'exact'), COALESCE(s.precision_meters, 0)
+        FROM user_locations l
+        LEFT JOIN user_settings s ON s.username = l.username
+        WHERE `+box+`NOT EXISTS (
+                SELECT 1 FROM user_locations n
+                WHERE n.username = l.username AND n.timestamp > l.timestamp)
+          AND `+visibleToViewer("l", "s", 3)+scopeFilter(scope, "l.username", 3)+`
+        ORDER BY l.username`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []nearbyUser
 	for rows.Next() {
-		var username string
-		var latitude, longitude float64
-		if err := rows.Scan(&username, &latitude, &longitude); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan row"})
-			return
+		var u nearbyUser
+		var privacy privacySetting
+		if err := rows.Scan(&u.Username, &u.Latitude, &u.Longitude, &privacy.Mode, &privacy.Meters); err != nil {
+			return nil, err
 		}
+		u.Latitude, u.Longitude = publicLocation(viewer, u.Username, u.Latitude, u.Longitude, privacy)
+		u.Distance = CalculateDistance(originLat, originLon, u.Latitude, u.Longitude)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
 
-		distance := CalculateDistance(request.Latitude, request.Longitude, latitude, longitude)
-		if distance <= request.Radius {
-			results = append(results, map[string]interface{}{
-				"username":  username,
-				"latitude":  latitude,
-				"longitude": longitude,
-				"distance":  distance,
-			})
+// Nearest-user searches start with a box of nearestStartMeters around the
+// origin and widen it until enough users are found, searching everyone
+// once the radius reaches nearestMaxMeters.
+const (
+	nearestStartMeters = 10000
+	nearestMaxMeters   = 20000000
+)
+
+// nearestPublic returns the limit other users whose public positions are
+// closest to the origin. Users are only ranked by their public position: a user
+// within radius of it is stored at most maxPrecisionMeters further away,
+// so a box widened by that much finds all of them.
+func nearestPublic(ctx context.Context, viewer, scope string, originLat, originLon float64, limit int) ([]nearbyUser, error) {
+	for radius := float64(nearestStartMeters); ; radius *= 4 {
+		box := radius + maxPrecisionMeters
+		if radius >= nearestMaxMeters {
+			box = 0
+		}
+		found, err := nearbyUsers(ctx, viewer, scope, originLat, originLon, box)
+		if err != nil {
+			return nil, err
 		}
+		users := []nearbyUser{}
+		for _, u := range found {
+			if u.Username != viewer {
+				users = append(users, u)
+			}
+		}
+		sort.SliceStable(users, func(i, j int) bool { return users[i].Distance < users[j].Distance })
+		if box == 0 {
+			return users[:min(limit, len(users))], nil
+		}
+		within := sort.Search(len(users), func(i int) bool { return users[i].Distance*1000 > radius })
+		if within >= limit {
+			return users[:limit], nil
+		}
+	}
+}
+
+// nearestUsers handles GET /users/nearest.
+// Requires any authenticated caller. Returns the latest position of the
+// closest other users, subject to the same visibility, privacy and scope
+// rules as searchUsers. Users are ranked by their public positions.
+func nearestUsers(c *gin.Context) {
+	latitude, longitude := queryFloat(c, "latitude"), queryFloat(c, "longitude")
+	limit := int64(10)
+	if s := c.Query("limit"); s != "" {
+		// Anything but a number parses as 0, which is out of range
+		limit, _ = strconv.ParseInt(s, 10, 64)
+	}
+	scope := c.Query("scope")
+	if scope == "" {
+		scope = "all"
+	}
+
+	var v validation.Validator
+	v.Latitude("latitude", latitude)
+	v.Longitude("longitude", longitude)
+	v.AtLeast("limit", limit, 1)
+	v.AtMost("limit", limit, 100)
+	v.OneOf("scope", scope, "all", "contacts")
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+
+	var viewer string
+	if p := currentPrincipal(c); p != nil {
+		viewer = p.Subject
+	}
+
+	results, err := nearestPublic(c.Request.Context(), viewer, scope, *latitude, *longitude, int(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
 	}
 
+	searchResults.WithLabelValues("nearest").Observe(float64(len(results)))
 	c.JSON(http.StatusOK, gin.H{
 		"users": results,
 		"total": len(results),
 	})
 }
 
+// newRouter registers every route of the HTTP API. openapi.json must
+// describe the same routes.
+func newRouter(auth *authenticator) *gin.Engine {
+	router := gin.New()
+	// gin trusts X-Forwarded-For from anyone by default; main configures
+	// the proxies that may set it.
+	router.SetTrustedProxies(nil)
+	router.Use(requestLogger(), recovery())
+	router.Use(otelgin.Middleware("location-management", otelgin.WithFilter(tracedRequest)))
+	router.Use(metricsMiddleware())
+	router.GET("/metrics", metricsHandler())
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+	router.GET("/openapi.json", openAPI)
+	router.GET("/docs", apiDocs)
+	router.GET("/docs/:asset", docsAsset)
+
+	// The unversioned routes predate /v1 and remain as deprecated aliases of
+	// it. /v2 serves the v1 routes until their response shapes change.
+	gateway := gin.WrapH(newGateway())
+	registerAPI(router.Group("/", apiVersion(versionUnversioned), auth.middleware()), auth, nil)
+	registerAPI(router.Group("/v1", apiVersion("v1"), auth.middleware()), auth, gateway)
+	registerAPI(router.Group("/v2", apiVersion("v2"), auth.middleware()), auth, gateway)
+	registerLive(router.Group("/v1", apiVersion("v1"), auth.streamMiddleware()))
+	registerLive(router.Group("/v2", apiVersion("v2"), auth.streamMiddleware()))
+
+	// Trackers cannot authenticate (see osmand), and anyone may verify a
+	// deletion receipt.
+	for _, version := range []string{"v1", "v2"} {
+		public := router.Group("/"+version, apiVersion(version))
+		public.GET("/osmand", osmand)
+		public.POST("/osmand", osmand)
+		public.GET("/receipts/public-key", receiptPublicKey)
+	}
+
+	return router
+}
+
 func main() {
-	db.InitDB()
-	defer db.DB.Close()
-	initGRPCClient()
-
-	router := gin.Default()
-	router.POST("/location/update", UpdateLocation)
-	router.GET("/users/search", searchUsers)
-	router.GET("/users/distance", CalculateTravelDistance)
-	router.Run(":8080")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
+	cfg, err := config.LoadManagement(*configPath)
+	if err != nil {
+		telemetry.Fatal("Failed to load configuration", err)
+	}
+	if err := telemetry.InitLogging("location-management", cfg.Logging); err != nil {
+		telemetry.Fatal("Failed to configure logging", err)
+	}
+	slog.Info("Effective configuration", "config", config.Redacted(cfg))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := telemetry.InitTracing(ctx, "location-management", cfg.Tracing)
+	if err != nil {
+		telemetry.Fatal("Failed to configure tracing", err)
+	}
+
+	if err := db.InitDB(cfg.Database); err != nil {
+		telemetry.Fatal("Failed to connect to database", err)
+	}
+	conn := initGRPCClient(cfg.History)
+
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		telemetry.Fatal("Failed to configure authentication", err)
+	}
+	if err := ensureAPIKeysTable(); err != nil {
+		telemetry.Fatal("Failed to create api_keys table", err)
+	}
+	auth.apiKeys = newAPIKeyStore(cfg.Auth.APIKeyRateLimit, cfg.Auth.APIKeyBurst)
+	if err := ensureUserSettingsTable(); err != nil {
+		telemetry.Fatal("Failed to create user_settings table", err)
+	}
+	if err := ensureContactsTable(); err != nil {
+		telemetry.Fatal("Failed to create contacts table", err)
+	}
+	if err := ensureLocationIDs(); err != nil {
+		telemetry.Fatal("Failed to number user_locations rows", err)
+	}
+	if err := ensureImportJobsTable(); err != nil {
+		telemetry.Fatal("Failed to create import_jobs table", err)
+	}
+	if err := failInterruptedImports(cfg.Imports.Dir); err != nil {
+		telemetry.Fatal("Failed to clean up interrupted imports", err)
+	}
+	if err := initPrivacy(cfg.PrivacySecret); err != nil {
+		telemetry.Fatal("Failed to configure privacy", err)
+	}
+	if err := initReceiptSigner(cfg.ReceiptSigningKey); err != nil {
+		telemetry.Fatal("Failed to configure receipt signing", err)
+	}
+	if err := initDocs(cfg.DocsAssetsDir); err != nil {
+		telemetry.Fatal("Failed to configure API docs", err)
+	}
+
+	// Validate has already checked the format.
+	legacySunset, _ = time.Parse(time.DateOnly, cfg.LegacySunset)
+	osmandEnabled = cfg.OsmAnd
+	liveFeed = newLiveHub(cfg.Live)
+	imports = newImporter(cfg.Imports)
+
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.DB, "locations"))
+
+	router := newRouter(auth)
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		telemetry.Fatal("Failed to configure trusted proxies", err)
+	}
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: router}
+	go func() {
+		slog.Info("LocationManagement HTTP server started", "addr", cfg.ListenAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			telemetry.Fatal("Failed to serve", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down LocationManagement")
+	shuttingDown.Store(true)
+	// Hijacked WebSocket connections are not drained by Shutdown.
+	liveFeed.shutdown()
+
+	// Stop accepting requests and drain the ones in flight before closing
+	// the connections they depend on.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP server did not drain in time", "error", err)
+		srv.Close()
+	}
+	// Running imports record that they were interrupted before the
+	// connections close.
+	imports.shutdown()
+	if err := conn.Close(); err != nil {
+		slog.Error("Failed to close LocationHistory connection", "error", err)
+	}
+	if err := db.DB.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("LocationManagement stopped")
 }
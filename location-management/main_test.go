@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -49,6 +50,7 @@ func setupTestDB() {
         longitude REAL,
         timestamp TIMESTAMP
     );
+    ALTER TABLE user_locations ADD COLUMN IF NOT EXISTS id BIGSERIAL;
     `
 	_, err = testDB.Exec(createTableQuery)
 	if err != nil {
@@ -82,7 +84,7 @@ func TestUpdateLocation(t *testing.T) {
 	db.DB = testDB
 
 	r := gin.Default()
-	r.POST("/location/update", UpdateLocation)
+	r.POST("/location/update", withPrincipal("testuser"), UpdateLocation)
 
 	// Test valid username
 	w := httptest.NewRecorder()
@@ -146,9 +148,11 @@ func TestSearchUsers(t *testing.T) {
 	defer teardownTestDB()
 
 	db.DB = testDB
+	assert.NoError(t, ensureUserSettingsTable())
+	assert.NoError(t, ensureContactsTable())
 
 	r := gin.Default()
-	r.GET("/users/search", searchUsers)
+	r.GET("/users/search", withPrincipal("testuser"), searchUsers)
 
 	// Insert some test data
 	testDB.Exec("INSERT INTO user_locations (username, latitude, longitude) VALUES (?, ?, ?)",
@@ -162,6 +166,133 @@ func TestSearchUsers(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "testuser")
 }
 
+func TestSearchUsersVisibility(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+
+	db.DB = testDB
+	assert.NoError(t, ensureUserSettingsTable())
+	assert.NoError(t, ensureContactsTable())
+
+	_, err := testDB.Exec("INSERT INTO user_locations (username, latitude, longitude) VALUES ($1, $2, $3)",
+		"hiddenuser", 37.7749, -122.4194)
+	assert.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO user_settings (username, visibility) VALUES ($1, $2)
+        ON CONFLICT (username) DO UPDATE SET visibility = EXCLUDED.visibility`, "hiddenuser", "invisible")
+	assert.NoError(t, err)
+
+	r := gin.Default()
+	r.GET("/users/search", withPrincipal("testuser"), searchUsers)
+	r.GET("/users/search/self", withPrincipal("hiddenuser"), searchUsers)
+
+	// Invisible users are hidden from others
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/search?latitude=37.7749&longitude=-122.4194&radius=1", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "hiddenuser")
+
+	// but still find themselves
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users/search/self?latitude=37.7749&longitude=-122.4194&radius=1", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "hiddenuser")
+}
+
+func TestSearchUsersPagination(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+
+	db.DB = testDB
+	assert.NoError(t, ensureUserSettingsTable())
+	assert.NoError(t, ensureContactsTable())
+
+	for _, username := range []string{"pageuser_a", "pageuser_b", "pageuser_c", "pageuser_d"} {
+		_, err := testDB.Exec(`INSERT INTO user_locations (username, latitude, longitude) VALUES ($1, $2, $3)
+            ON CONFLICT (username) DO UPDATE SET latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude`,
+			username, 10.001, 10.001)
+		assert.NoError(t, err)
+	}
+	// pageuser_b's public position is the center of a 20 km grid cell,
+	// outside the 1 km radius.
+	_, err := testDB.Exec(`INSERT INTO user_settings (username, precision_mode, precision_meters) VALUES ($1, 'grid', 20000)
+        ON CONFLICT (username) DO UPDATE SET precision_mode = EXCLUDED.precision_mode, precision_meters = EXCLUDED.precision_meters`,
+		"pageuser_b")
+	assert.NoError(t, err)
+
+	r := gin.Default()
+	r.GET("/users/search", withPrincipal("testuser"), searchUsers)
+	page := func(n int) (users []string, total int) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/users/search?latitude=10.001&longitude=10.001&radius=1&page=%d&page_size=2", n), nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body struct {
+			Users []struct {
+				Username string `json:"username"`
+			} `json:"users"`
+			Total int `json:"total"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		for _, u := range body.Users {
+			users = append(users, u.Username)
+		}
+		return users, body.Total
+	}
+
+	// The filtered user neither shortens the first page nor shifts the
+	// offset of the second.
+	users, total := page(1)
+	assert.Equal(t, []string{"pageuser_a", "pageuser_c"}, users)
+	assert.Equal(t, 3, total)
+	users, total = page(2)
+	assert.Equal(t, []string{"pageuser_d"}, users)
+	assert.Equal(t, 3, total)
+}
+
+func TestNearestUsersRanking(t *testing.T) {
+	setupTestDB()
+	defer teardownTestDB()
+
+	db.DB = testDB
+	assert.NoError(t, ensureUserSettingsTable())
+	assert.NoError(t, ensureContactsTable())
+
+	// nearuser_a is stored at the origin but shown at the center of a
+	// 20 km grid cell; nearuser_b is 5 km away.
+	positions := map[string][2]float64{"nearuser_a": {-33.5, 151.2}, "nearuser_b": {-33.545, 151.2}}
+	for username, pos := range positions {
+		_, err := testDB.Exec(`INSERT INTO user_locations (username, latitude, longitude) VALUES ($1, $2, $3)
+            ON CONFLICT (username) DO UPDATE SET latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude`,
+			username, pos[0], pos[1])
+		assert.NoError(t, err)
+	}
+	_, err := testDB.Exec(`INSERT INTO user_settings (username, precision_mode, precision_meters) VALUES ($1, 'grid', 20000)
+        ON CONFLICT (username) DO UPDATE SET precision_mode = EXCLUDED.precision_mode, precision_meters = EXCLUDED.precision_meters`,
+		"nearuser_a")
+	assert.NoError(t, err)
+
+	publicDistance := func(username string, s privacySetting) float64 {
+		lat, lon := publicLocation("testuser", username, positions[username][0], positions[username][1], s)
+		return CalculateDistance(-33.5, 151.2, lat, lon)
+	}
+	a := publicDistance("nearuser_a", privacySetting{Mode: "grid", Meters: 20000})
+	b := publicDistance("nearuser_b", privacySetting{Mode: "exact"})
+
+	users, err := nearestPublic(context.Background(), "testuser", "all", -33.5, 151.2, 1)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	// The exact position of nearuser_a does not decide the ranking
+	assert.Equal(t, math.Min(a, b), users[0].Distance)
+	if b < a {
+		assert.Equal(t, "nearuser_b", users[0].Username)
+	}
+}
+
 func TestCalculateTravelDistance(t *testing.T) {
 	setupTestDB()
 	defer teardownTestDB()
@@ -169,7 +300,7 @@ func TestCalculateTravelDistance(t *testing.T) {
 	db.DB = testDB
 
 	r := gin.Default()
-	r.GET("/users/distance", CalculateTravelDistance)
+	r.GET("/users/distance", withPrincipal("testuser"), CalculateTravelDistance)
 
 	// Insert some test data
 	_, err := testDB.Exec("INSERT INTO user_locations (username, latitude, longitude, timestamp) VALUES ($1, $2, $3, $4)",
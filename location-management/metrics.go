@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	updateAccepted = "accepted"
+	updateRejected = "rejected"
+	updateFailed   = "failed"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_management_http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "location_management_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	apiVersionRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_management_api_version_requests_total",
+		Help: "API requests by version: v1, v2 or unversioned (deprecated aliases of v1).",
+	}, []string{"version"})
+
+	liveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "location_management_live_connections",
+		Help: "Open live map WebSocket connections.",
+	})
+
+	locationUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_management_location_updates_total",
+		Help: "Location updates by result: accepted, rejected (invalid or not allowed) or failed.",
+	}, []string{"result"})
+
+	searchResults = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "location_management_search_results",
+		Help:    "Number of users returned per search.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"endpoint"})
+
+	historyCallFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_management_history_call_failures_total",
+		Help: "Failed calls to the LocationHistory service by method and gRPC code.",
+	}, []string{"method", "code"})
+
+	historyRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "location_management_history_call_retries_total",
+		Help: "Retried calls to the LocationHistory service by method.",
+	}, []string{"method"})
+
+	historyBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "location_management_history_breaker_open",
+		Help: "1 while the LocationHistory circuit breaker is open, 0 otherwise.",
+	})
+)
+
+// metricsMiddleware records the count and latency of every request, labelled
+// with the route template rather than the raw path to bound cardinality.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		code := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, code).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler serves GET /metrics.
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// countHistoryFailures counts failed calls made through locationHistoryClient.
+func countHistoryFailures(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		historyCallFailures.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	r.GET("/metrics", metricsHandler())
+	r.GET("/users/:username/privacy", func(c *gin.Context) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to access this user's data"})
+	})
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/users/:username/privacy", "403"))
+	for _, user := range []string{"testuser", "otheruser"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/"+user+"/privacy", nil)
+		r.ServeHTTP(w, req)
+	}
+	// Both users are counted under the route template.
+	assert.Equal(t, before+2, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/users/:username/privacy", "403")))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `location_management_http_requests_total{method="GET",route="/users/:username/privacy",status="403"}`)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var openAPIFile []byte
+
+// openAPISpec documents every route registered in newRouter.
+// TestOpenAPIMatchesRoutes fails when the two diverge.
+var openAPISpec = versionedSpec(openAPIFile)
+
+// versionedSpec adds the routes openapi.json leaves implicit: /v2 mirrors
+// every /v1 path, and /v1 paths marked x-unversioned-alias are also served
+// without the prefix as deprecated aliases.
+func versionedSpec(raw []byte) []byte {
+	var doc map[string]json.RawMessage
+	var documented map[string]map[string]json.RawMessage
+	err := json.Unmarshal(raw, &doc)
+	if err == nil {
+		err = json.Unmarshal(doc["paths"], &documented)
+	}
+	if err != nil {
+		panic("openapi.json: " + err.Error())
+	}
+
+	paths := make(map[string]map[string]json.RawMessage)
+	for path, item := range documented {
+		_, alias := item["x-unversioned-alias"]
+		delete(item, "x-unversioned-alias")
+		paths[path] = item
+
+		rest, ok := strings.CutPrefix(path, "/v1/")
+		if !ok {
+			continue
+		}
+		paths["/v2/"+rest] = copyOperations(item, func(op map[string]interface{}) {
+			op["operationId"] = "v2" + strings.TrimPrefix(op["operationId"].(string), "v1")
+		})
+		if alias {
+			paths["/"+rest] = copyOperations(item, func(op map[string]interface{}) {
+				id := strings.TrimPrefix(op["operationId"].(string), "v1")
+				op["operationId"] = strings.ToLower(id[:1]) + id[1:]
+				op["deprecated"] = true
+			})
+		}
+	}
+
+	doc["paths"], _ = json.Marshal(paths)
+	out, _ := json.MarshalIndent(doc, "", "  ")
+	return out
+}
+
+// copyOperations returns the operations of a path item, each passed through
+// edit.
+func copyOperations(item map[string]json.RawMessage, edit func(op map[string]interface{})) map[string]json.RawMessage {
+	ops := make(map[string]json.RawMessage, len(item))
+	for method, raw := range item {
+		var op map[string]interface{}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			panic("openapi.json: " + err.Error())
+		}
+		edit(op)
+		ops[method], _ = json.Marshal(op)
+	}
+	return ops
+}
+
+// docsAssets is the directory holding the swagger-ui-dist files /docs
+// serves. The page loads nothing from other origins, so /docs is off
+// until it is configured.
+var docsAssets string
+
+// docsFiles are the Swagger UI files /docs/{asset} serves.
+var docsFiles = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+}
+
+// initDocs serves the Swagger UI files in dir, which must contain every
+// file in docsFiles. An empty dir disables /docs.
+func initDocs(dir string) error {
+	if dir == "" {
+		docsAssets = ""
+		return nil
+	}
+	for name := range docsFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("docs assets: %w", err)
+		}
+	}
+	docsAssets = dir
+	return nil
+}
+
+const docsScript = `SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui", validatorUrl: null});`
+
+// docsPage renders openapi.json with Swagger UI.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Location Management API</title>
+  <link rel="stylesheet" href="/docs/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/docs/swagger-ui-bundle.js"></script>
+  <script>` + docsScript + `</script>
+</body>
+</html>
+`
+
+// docsPolicy limits /docs to the service's own Swagger UI files, the
+// page's inline script and the spec.
+var docsPolicy = func() string {
+	sum := sha256.Sum256([]byte(docsScript))
+	return strings.Join([]string{
+		"default-src 'none'",
+		"script-src 'self' 'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' data:",
+		"connect-src 'self'",
+		"base-uri 'none'",
+		"form-action 'none'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}()
+
+// openAPI handles GET /openapi.json.
+func openAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+// apiDocs handles GET /docs.
+func apiDocs(c *gin.Context) {
+	if docsAssets == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API docs are not configured"})
+		return
+	}
+	c.Header("Content-Security-Policy", docsPolicy)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+// docsAsset handles GET /docs/:asset.
+func docsAsset(c *gin.Context) {
+	contentType, ok := docsFiles[c.Param("asset")]
+	if docsAssets == "" || !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Docs asset not found"})
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.File(filepath.Join(docsAssets, c.Param("asset")))
+}
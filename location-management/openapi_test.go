@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters []openAPIParameter         `json:"parameters"`
+	Responses  map[string]json.RawMessage `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+var (
+	ginParam     = regexp.MustCompile(`:(\w+)`)
+	openAPIParam = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var doc openAPIDocument
+	assert.NoError(t, json.Unmarshal(openAPISpec, &doc))
+
+	var documented []string
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+			assert.NotEmpty(t, op.Responses, "%s %s has no responses", method, path)
+
+			// Every path parameter is declared
+			declared := map[string]bool{}
+			for _, p := range op.Parameters {
+				if p.Ref != "" {
+					p = doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+				}
+				if p.In == "path" {
+					declared[p.Name] = true
+				}
+			}
+			for _, m := range openAPIParam.FindAllStringSubmatch(path, -1) {
+				assert.True(t, declared[m[1]], "%s %s does not declare path parameter %s", method, path, m[1])
+			}
+		}
+	}
+
+	var registered []string
+	router := newRouter(&authenticator{apiKeys: newAPIKeyStore(1, 1)})
+	for _, r := range router.Routes() {
+		registered = append(registered, r.Method+" "+ginParam.ReplaceAllString(r.Path, "{$1}"))
+	}
+
+	sort.Strings(documented)
+	sort.Strings(registered)
+	assert.Equal(t, registered, documented, "openapi.json and the routes in newRouter differ")
+}
+
+func TestOpenAPIHandlers(t *testing.T) {
+	router := newRouter(&authenticator{apiKeys: newAPIKeyStore(1, 1)})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(w.Body.Bytes()))
+
+	// /docs is off until the Swagger UI files are configured
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/docs", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	dir := t.TempDir()
+	assert.Error(t, initDocs(dir))
+	for name := range docsFiles {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("/* "+name+" */"), 0o644))
+	}
+	assert.NoError(t, initDocs(dir))
+	t.Cleanup(func() { docsAssets = "" })
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/docs", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `url: "/openapi.json"`)
+	assert.Contains(t, w.Body.String(), `src="/docs/swagger-ui-bundle.js"`)
+	assert.NotContains(t, w.Body.String(), "https://")
+
+	// Only the service's own bundle and the page's script may run
+	policy := w.Header().Get("Content-Security-Policy")
+	sum := sha256.Sum256([]byte(docsScript))
+	assert.Contains(t, policy, "script-src 'self' 'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+	assert.Contains(t, w.Body.String(), "<script>"+docsScript+"</script>")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/docs/swagger-ui-bundle.js", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "/* swagger-ui-bundle.js */", w.Body.String())
+
+	// Only the Swagger UI files are served from the directory
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0o644))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/docs/other.txt", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// osmandEnabled is the osmand setting. The route is always registered so
+// the API stays the same; it answers 404 while disabled.
+var osmandEnabled bool
+
+const knotsToMetersPerSecond = 1852.0 / 3600
+
+// Tracker reports are limited per client IP, checked before the device
+// lookup, and per device ID. The IP limit leaves room for a fleet behind
+// one NAT; a single device rarely reports more than once a second. Lookups
+// of device IDs not yet seen registered share one global limit, so probing
+// for registered IDs stays slow however many addresses it comes from.
+const (
+	osmandIPRate      = 10
+	osmandIPBurst     = 20
+	osmandDeviceRate  = 1
+	osmandDeviceBurst = 5
+	osmandLookupRate  = 5
+	osmandLookupBurst = 20
+
+	// osmandLimiterIdle is how long an unused limiter is kept.
+	osmandLimiterIdle = 10 * time.Minute
+)
+
+// trackerLimiter holds one token bucket per key. Buckets unused for
+// osmandLimiterIdle are dropped so probing random keys cannot grow the
+// map without bound.
+type trackerLimiter struct {
+	rate  rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*trackerBucket
+	swept    time.Time
+}
+
+type trackerBucket struct {
+	lim  *rate.Limiter
+	used time.Time
+	// known is set once the key was found to be a registered device.
+	known bool
+}
+
+func newTrackerLimiter(r float64, burst int) *trackerLimiter {
+	return &trackerLimiter{rate: rate.Limit(r), burst: burst, limiters: make(map[string]*trackerBucket)}
+}
+
+var (
+	osmandIPLimiter     = newTrackerLimiter(osmandIPRate, osmandIPBurst)
+	osmandDeviceLimiter = newTrackerLimiter(osmandDeviceRate, osmandDeviceBurst)
+	osmandLookupLimiter = newTrackerLimiter(osmandLookupRate, osmandLookupBurst)
+)
+
+// allow takes a token from key's bucket. When the bucket is empty it
+// returns false and how long the caller should wait before retrying.
+func (l *trackerLimiter) allow(key string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.swept) > osmandLimiterIdle {
+		for k, b := range l.limiters {
+			if now.Sub(b.used) > osmandLimiterIdle {
+				delete(l.limiters, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.limiters[key]
+	if !ok {
+		b = &trackerBucket{lim: rate.NewLimiter(l.rate, l.burst)}
+		l.limiters[key] = b
+	}
+	b.used = now
+	l.mu.Unlock()
+
+	r := b.lim.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Second, false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// known reports whether setKnown was called for key since its bucket was
+// created.
+func (l *trackerLimiter) known(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.limiters[key]
+	return ok && b.known
+}
+
+func (l *trackerLimiter) setKnown(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.limiters[key]; ok {
+		b.known = true
+	}
+}
+
+// rateLimited answers 429 when key has used up its bucket in l.
+func rateLimited(c *gin.Context, l *trackerLimiter, key string) bool {
+	wait, ok := l.allow(key)
+	if ok {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+	return true
+}
+
+// osmandTimestamp parses Unix seconds, Unix milliseconds or an RFC 3339
+// time, the forms trackers send.
+func osmandTimestamp(value string) (int64, bool) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Seconds stay below 1e12 until the year 33658.
+		if n >= 1e12 {
+			n /= 1000
+		}
+		return n, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), true
+	}
+	return 0, false
+}
+
+// osmandRequest converts the parameters of an OsmAnd report for username.
+// speed is in knots, as Traccar Client sends it; accuracy and altitude are
+// in meters. Missing parameters are left for LocationRequest.Validate.
+func osmandRequest(form url.Values, username string) (*pb.LocationRequest, error) {
+	var errs validation.Errors
+	number := func(name string) *float64 {
+		value := form.Get(name)
+		if value == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			errs = append(errs, validation.FieldError{Field: name, Message: "Invalid " + name + ". Must be a number"})
+			return nil
+		}
+		return &f
+	}
+
+	req := &pb.LocationRequest{
+		Username:  username,
+		Latitude:  number("lat"),
+		Longitude: number("lon"),
+		Accuracy:  number("accuracy"),
+		Altitude:  number("altitude"),
+	}
+	if speed := number("speed"); speed != nil {
+		*speed *= knotsToMetersPerSecond
+		req.Speed = speed
+	}
+	if value := form.Get("timestamp"); value != "" {
+		ts, ok := osmandTimestamp(value)
+		if !ok {
+			errs = append(errs, validation.FieldError{Field: "timestamp", Message: validation.MsgTimestamp})
+		}
+		req.TimestampUnix = ts
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return req, nil
+}
+
+// osmand handles GET and POST /v1/osmand, the OsmAnd protocol spoken by
+// Traccar Client, OsmAnd and many hardware trackers. Parameters may be in
+// the query string or a form body.
+// Requires the osmand setting and a registered device as id (or deviceid);
+// trackers cannot send credentials, so the position is stored for the
+// device's owner through UpdateLocation. Reports are rate limited per
+// client IP and per device ID, and lookups of unknown device IDs globally.
+func osmand(c *gin.Context) {
+	if !osmandEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OsmAnd endpoint is disabled"})
+		return
+	}
+	if rateLimited(c, osmandIPLimiter, c.ClientIP()) {
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		invalidRequest(c, validation.Errors{{Field: "form", Message: "Invalid parameters. Must be URL-encoded"}})
+		return
+	}
+	form := c.Request.Form
+	deviceID := form.Get("id")
+	if deviceID == "" {
+		deviceID = form.Get("deviceid")
+	}
+	var v validation.Validator
+	v.DeviceID("id", deviceID)
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if rateLimited(c, osmandDeviceLimiter, deviceID) {
+		return
+	}
+	if !osmandDeviceLimiter.known(deviceID) && rateLimited(c, osmandLookupLimiter, "") {
+		return
+	}
+
+	device, err := locationHistoryClient.GetDevice(c.Request.Context(), &pb.GetDeviceRequest{DeviceId: deviceID})
+	if status.Code(err) == codes.NotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown device"})
+		return
+	}
+	if err != nil {
+		historyFailure(c, err, http.StatusInternalServerError, "Failed to look up device")
+		return
+	}
+
+	osmandDeviceLimiter.setKnown(deviceID)
+
+	req, err := osmandRequest(form, device.GetUsername())
+	if err != nil {
+		locationUpdates.WithLabelValues(updateRejected).Inc()
+		invalidRequest(c, err)
+		return
+	}
+	// The device reports on behalf of its owner.
+	setPrincipal(c, &principal{Subject: device.GetUsername()})
+	if _, err := locationService.UpdateLocation(c.Request.Context(), req); err != nil {
+		respondRPCError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
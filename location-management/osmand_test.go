@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOsmAndTimestamp(t *testing.T) {
+	for value, want := range map[string]int64{
+		"1617188765":           1617188765,
+		"1617188765123":        1617188765,
+		"2021-03-31T11:06:05Z": 1617188765,
+	} {
+		ts, ok := osmandTimestamp(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, ts, value)
+	}
+	_, ok := osmandTimestamp("yesterday")
+	assert.False(t, ok)
+}
+
+func TestOsmAndRequest(t *testing.T) {
+	form, _ := url.ParseQuery("id=truck-1&lat=44.43&lon=26.1&timestamp=1617188765&speed=10&accuracy=5&altitude=80&batt=90")
+	req, err := osmandRequest(form, "testuser")
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", req.GetUsername())
+	assert.Equal(t, 44.43, req.GetLatitude())
+	assert.Equal(t, 26.1, req.GetLongitude())
+	assert.Equal(t, int64(1617188765), req.GetTimestampUnix())
+	assert.InDelta(t, 5.144, req.GetSpeed(), 1e-3)
+	assert.Equal(t, 5.0, req.GetAccuracy())
+	assert.Equal(t, 80.0, req.GetAltitude())
+
+	form, _ = url.ParseQuery("id=truck-1&lat=north&lon=26.1&timestamp=soon")
+	_, err = osmandRequest(form, "testuser")
+	assert.Equal(t, validation.Errors{
+		{Field: "lat", Message: "Invalid lat. Must be a number"},
+		{Field: "timestamp", Message: validation.MsgTimestamp},
+	}, err)
+}
+
+func TestOsmAndEndpoint(t *testing.T) {
+	useHistoryClient(t, deviceHistoryClient{})
+	defer func(enabled bool) { osmandEnabled = enabled }(osmandEnabled)
+	resetTrackerLimiters(t)
+	r := gin.New()
+	r.GET("/v1/osmand", osmand)
+	r.POST("/v1/osmand", osmand)
+	do := func(req *http.Request) (*httptest.ResponseRecorder, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w, w.Body.String()
+	}
+	get := func(query string) *http.Request {
+		req, _ := http.NewRequest("GET", "/v1/osmand?"+query, nil)
+		return req
+	}
+
+	osmandEnabled = false
+	w, _ := do(get("id=truck-1&lat=1&lon=1"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	osmandEnabled = true
+	w, body := do(get("id=truck%201&lat=1&lon=1"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, validation.MsgDeviceID)
+
+	w, body = do(get("id=truck-1&lat=%zz"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, `"field":"form"`)
+
+	w, body = do(get("id=truck-2&lat=1&lon=1"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, body, "Unknown device")
+
+	// Form bodies work too; the position is validated like any update
+	req, _ := http.NewRequest("POST", "/v1/osmand", strings.NewReader("deviceid=truck-1&lat=91&lon=1&timestamp="+time.Now().Format(time.RFC3339)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w, body = do(req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, validation.MsgLatitude)
+
+	// Unknown device IDs still use up the client's IP bucket
+	osmandIPLimiter = newTrackerLimiter(1, 1)
+	w, _ = do(get("id=truck-3&lat=1&lon=1"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, body = do(get("id=truck-4&lat=1&lon=1"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, body, "Rate limit exceeded")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// Lookups of unknown devices share one budget; registered devices
+	// already seen skip it.
+	osmandIPLimiter = newTrackerLimiter(osmandIPRate, osmandIPBurst)
+	osmandLookupLimiter = newTrackerLimiter(1, 1)
+	w, _ = do(get("id=truck-5&lat=1&lon=1"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = do(get("id=truck-6&lat=1&lon=1"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w, body = do(get("id=truck-1&lat=91&lon=1"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, validation.MsgLatitude)
+}
+
+// resetTrackerLimiters gives the test fresh OsmAnd limiters.
+func resetTrackerLimiters(t *testing.T) {
+	ip, device, lookup := osmandIPLimiter, osmandDeviceLimiter, osmandLookupLimiter
+	t.Cleanup(func() { osmandIPLimiter, osmandDeviceLimiter, osmandLookupLimiter = ip, device, lookup })
+	osmandIPLimiter = newTrackerLimiter(osmandIPRate, osmandIPBurst)
+	osmandDeviceLimiter = newTrackerLimiter(osmandDeviceRate, osmandDeviceBurst)
+	osmandLookupLimiter = newTrackerLimiter(osmandLookupRate, osmandLookupBurst)
+}
+
+func TestOsmAndClientIP(t *testing.T) {
+	useHistoryClient(t, deviceHistoryClient{})
+	defer func(enabled bool) { osmandEnabled = enabled }(osmandEnabled)
+	osmandEnabled = true
+	resetTrackerLimiters(t)
+	osmandIPLimiter = newTrackerLimiter(1, 1)
+	r := newRouter(&authenticator{apiKeys: newAPIKeyStore(1, 1)})
+
+	// X-Forwarded-For from an untrusted peer does not get a new bucket
+	for i, want := range []int{http.StatusNotFound, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/osmand?id=truck-2&lat=1&lon=1", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code)
+	}
+}
+
+func TestTrackerLimiter(t *testing.T) {
+	l := newTrackerLimiter(1, 2)
+	for i := 0; i < 2; i++ {
+		_, ok := l.allow("truck-1")
+		assert.True(t, ok)
+	}
+	wait, ok := l.allow("truck-1")
+	assert.False(t, ok)
+	assert.Greater(t, wait, time.Duration(0))
+
+	// Keys have their own buckets
+	_, ok = l.allow("truck-2")
+	assert.True(t, ok)
+
+	// Idle buckets are dropped on the next sweep
+	l.limiters["truck-1"].used = time.Now().Add(-2 * osmandLimiterIdle)
+	l.swept = time.Time{}
+	_, ok = l.allow("truck-2")
+	assert.True(t, ok)
+	assert.NotContains(t, l.limiters, "truck-1")
+}
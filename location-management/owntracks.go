@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// owntracksMessage is the subset of the OwnTracks JSON format used here:
+// location reports from the app, and the location and card messages sent
+// back so the app can show contacts.
+type owntracksMessage struct {
+	Type  string   `json:"_type"`
+	TID   string   `json:"tid,omitempty"`
+	Topic string   `json:"topic,omitempty"`
+	Lat   *float64 `json:"lat,omitempty"`
+	Lon   *float64 `json:"lon,omitempty"`
+	Tst   int64    `json:"tst,omitempty"`
+	// Meters.
+	Acc *float64 `json:"acc,omitempty"`
+	// Meters above sea level.
+	Alt *float64 `json:"alt,omitempty"`
+	// Kilometers per hour.
+	Vel  *float64 `json:"vel,omitempty"`
+	Name string   `json:"name,omitempty"`
+}
+
+// locationRequest converts a location report of username.
+func (m owntracksMessage) locationRequest(username string) *pb.LocationRequest {
+	req := &pb.LocationRequest{
+		Username:      username,
+		Latitude:      m.Lat,
+		Longitude:     m.Lon,
+		TimestampUnix: m.Tst,
+		Accuracy:      m.Acc,
+		Altitude:      m.Alt,
+	}
+	if m.Vel != nil {
+		speed := *m.Vel / 3.6
+		req.Speed = &speed
+	}
+	return req
+}
+
+// owntracksTID is the two-character label the app shows on a contact's
+// pin.
+func owntracksTID(username string) string {
+	return strings.ToUpper(username[:2])
+}
+
+// owntracksContact describes the latest position of a contact the way the
+// app expects it from its own recorder: a card naming the contact and a
+// location on the contact's topic.
+func owntracksContact(p livePosition) []owntracksMessage {
+	topic := "owntracks/" + p.Username + "/" + p.Username
+	tid := owntracksTID(p.Username)
+	return []owntracksMessage{
+		{Type: "card", TID: tid, Topic: topic, Name: p.Username},
+		{Type: "location", TID: tid, Topic: topic, Lat: &p.Latitude, Lon: &p.Longitude, Tst: p.Timestamp},
+	}
+}
+
+// contactPositions returns the latest position of each accepted contact of
+// username that is visible to them, coarsened like in search.
+func contactPositions(ctx context.Context, username string) ([]livePosition, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+        SELECT DISTINCT ON (l.username) l.username, l.latitude, l.longitude, l.timestamp,
+               COALESCE(s.precision_mode, 'exact'), COALESCE(s.precision_meters, 0)
+        FROM user_locations l
+        LEFT JOIN user_settings s ON s.username = l.username
+        WHERE l.username <> $1
+          AND `+isContactOf("l.username", 1)+`
+          AND `+visibleToViewer("l", "s", 1)+`
+        ORDER BY l.username, l.timestamp DESC`,
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []livePosition
+	for rows.Next() {
+		var p livePosition
+		var timestamp time.Time
+		var privacy privacySetting
+		if err := rows.Scan(&p.Username, &p.Latitude, &p.Longitude, &timestamp, &privacy.Mode, &privacy.Meters); err != nil {
+			return nil, err
+		}
+		p.Latitude, p.Longitude = publicLocation(username, p.Username, p.Latitude, p.Longitude, privacy)
+		p.Timestamp = timestamp.Unix()
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+// owntracksUser maps a report to a username. A tid registered as a device
+// ID belongs to the device's owner; otherwise the app's X-Limit-U header
+// names the user, defaulting to the caller. Reports whose registered tid
+// and X-Limit-U disagree are rejected. On failure the response has been
+// written.
+func owntracksUser(c *gin.Context, tid string) (string, bool) {
+	username := c.GetHeader("X-Limit-U")
+	if tid != "" && validation.IsValidDeviceID(tid) {
+		device, err := locationHistoryClient.GetDevice(c.Request.Context(), &pb.GetDeviceRequest{DeviceId: tid})
+		switch {
+		case status.Code(err) == codes.NotFound:
+			// Unregistered tids are only pin labels.
+		case err != nil:
+			historyFailure(c, err, http.StatusInternalServerError, "Failed to look up device")
+			return "", false
+		case username != "" && username != device.GetUsername():
+			invalidRequest(c, validation.Errors{{Field: "tid", Message: "Invalid tid. Registered to another user"}})
+			return "", false
+		default:
+			return device.GetUsername(), true
+		}
+	}
+	if username == "" {
+		if p := currentPrincipal(c); p != nil {
+			username = p.Subject
+		}
+	}
+	return username, true
+}
+
+// owntracks handles POST /v1/owntracks, the URL of the OwnTracks app in
+// HTTP mode.
+// Requires the caller to be the user, or to hold the admin scope. The user
+// is resolved by owntracksUser. Location reports go through UpdateLocation and are answered with
+// the latest positions of the user's contacts; other message types are
+// acknowledged with an empty list.
+func owntracks(c *gin.Context) {
+	var msg owntracksMessage
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		invalidBody(c, err)
+		return
+	}
+
+	username, ok := owntracksUser(c, msg.TID)
+	if !ok {
+		return
+	}
+	var v validation.Validator
+	v.Username("username", username)
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	if msg.Type != "location" {
+		c.JSON(http.StatusOK, []owntracksMessage{})
+		return
+	}
+	if _, err := locationService.UpdateLocation(c.Request.Context(), msg.locationRequest(username)); err != nil {
+		respondRPCError(c, err)
+		return
+	}
+
+	positions, err := contactPositions(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+	reply := []owntracksMessage{}
+	for _, p := range positions {
+		reply = append(reply, owntracksContact(p)...)
+	}
+	c.JSON(http.StatusOK, reply)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOwnTracksLocationRequest(t *testing.T) {
+	var msg owntracksMessage
+	assert.NoError(t, json.Unmarshal([]byte(`{"_type":"location","tid":"te","lat":44.43,"lon":26.1,"tst":1617188765,"acc":12,"alt":80,"vel":36,"batt":90}`), &msg))
+
+	req := msg.locationRequest("testuser")
+	assert.Equal(t, "testuser", req.GetUsername())
+	assert.Equal(t, 44.43, req.GetLatitude())
+	assert.Equal(t, 26.1, req.GetLongitude())
+	assert.Equal(t, int64(1617188765), req.GetTimestampUnix())
+	assert.Equal(t, proto.Float64(12), req.Accuracy)
+	assert.Equal(t, proto.Float64(80), req.Altitude)
+	assert.Equal(t, proto.Float64(10), req.Speed)
+
+	req = owntracksMessage{Type: "location"}.locationRequest("testuser")
+	assert.Nil(t, req.Latitude)
+	assert.Nil(t, req.Speed)
+}
+
+func TestOwnTracksContact(t *testing.T) {
+	data, err := json.Marshal(owntracksContact(livePosition{Username: "friend1", Latitude: 1.5, Longitude: 2.5, Timestamp: 3}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"_type": "card", "tid": "FR", "topic": "owntracks/friend1/friend1", "name": "friend1"},
+		{"_type": "location", "tid": "FR", "topic": "owntracks/friend1/friend1", "lat": 1.5, "lon": 2.5, "tst": 3}
+	]`, string(data))
+}
+
+func TestOwnTracksEndpoint(t *testing.T) {
+	useHistoryClient(t, deviceHistoryClient{})
+	r := gin.New()
+	r.POST("/v1/owntracks", withPrincipal("testuser"), owntracks)
+	do := func(user, body string) (*httptest.ResponseRecorder, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/owntracks", bytes.NewBufferString(body))
+		if user != "" {
+			req.Header.Set("X-Limit-U", user)
+		}
+		r.ServeHTTP(w, req)
+		return w, w.Body.String()
+	}
+
+	// Other message types are acknowledged so the app drops them
+	w, body := do("", `{"_type":"transition","event":"enter"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[]", body)
+
+	w, _ = do("otheruser", `{"_type":"location","lat":1,"lon":1}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, body = do("ab", `{"_type":"location","lat":1,"lon":1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, "Invalid username")
+
+	w, body = do("testuser", `{"_type":"location","lat":91,"lon":1,"vel":-1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, "Invalid latitude")
+	assert.Contains(t, body, "Invalid speed. Must not be negative")
+
+	w, body = do("testuser", `not json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, `"field":"body"`)
+
+	w, body = do("testuser", `{"_type":"location","lat":"north","lon":1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, `{"field":"lat","message":"Invalid lat. Must be a number"}`)
+	// A registered tid maps the report to the device's owner
+	w, _ = do("", `{"_type":"transition","tid":"truck-1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, body = do("otheruser", `{"_type":"location","tid":"truck-1","lat":1,"lon":1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, body, "Registered to another user")
+}
+
+func TestOwnTracksUser(t *testing.T) {
+	useHistoryClient(t, deviceHistoryClient{})
+	resolve := func(header, tid string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/v1/owntracks", nil)
+		if header != "" {
+			c.Request.Header.Set("X-Limit-U", header)
+		}
+		setPrincipal(c, &principal{Subject: "caller"})
+		username, ok := owntracksUser(c, tid)
+		assert.True(t, ok)
+		return username
+	}
+
+	assert.Equal(t, "testuser", resolve("", "truck-1"))
+	assert.Equal(t, "testuser", resolve("testuser", "truck-1"))
+	// Unregistered tids fall back to the header, then the caller
+	assert.Equal(t, "phoneuser", resolve("phoneuser", "PH"))
+	assert.Equal(t, "caller", resolve("", "PH"))
+	assert.Equal(t, "caller", resolve("", ""))
+}
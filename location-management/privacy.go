@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	precisionExact  = "exact"
+	precisionGrid   = "grid"
+	precisionOffset = "offset"
+
+	// maxPrecisionMeters bounds how far a public position may be moved, so
+	// searches only need to widen their bounding box by this much.
+	maxPrecisionMeters = 20000
+
+	metersPerDegree = 111320.0
+)
+
+// privacySecret keys the stable per-user offsets. Without a configured
+// secret it is random, and offsets change whenever the service restarts.
+var privacySecret []byte
+
+type privacySetting struct {
+	Mode   string  `json:"mode"`
+	Meters float64 `json:"meters"`
+}
+
+func initPrivacy(secret string) error {
+	if secret != "" {
+		privacySecret = []byte(secret)
+		return nil
+	}
+	slog.Warn("No privacy secret configured, privacy offsets will change on restart")
+	privacySecret = make([]byte, 32)
+	_, err := rand.Read(privacySecret)
+	return err
+}
+
+func ensureUserSettingsTable() error {
+	_, err := db.DB.Exec(`
+        CREATE TABLE IF NOT EXISTS user_settings (
+            username TEXT PRIMARY KEY,
+            precision_mode TEXT NOT NULL DEFAULT 'exact',
+            precision_meters DOUBLE PRECISION NOT NULL DEFAULT 0
+        );
+        ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public'`)
+	return err
+}
+
+// fuzzLocation returns the position of username that may be shown to other
+// users under setting s.
+func fuzzLocation(username string, lat, lon float64, s privacySetting) (float64, float64) {
+	if s.Meters <= 0 {
+		return lat, lon
+	}
+
+	switch s.Mode {
+	case precisionGrid:
+		latStep := s.Meters / metersPerDegree
+		lat = (math.Floor(lat/latStep) + 0.5) * latStep
+		lat = math.Max(-90, math.Min(90, lat))
+		lonStep := s.Meters / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+		lon = (math.Floor(lon/lonStep) + 0.5) * lonStep
+	case precisionOffset:
+		mac := hmac.New(sha256.New, privacySecret)
+		mac.Write([]byte(username))
+		sum := mac.Sum(nil)
+		u1 := float64(binary.BigEndian.Uint64(sum[0:8])) / math.MaxUint64
+		u2 := float64(binary.BigEndian.Uint64(sum[8:16])) / math.MaxUint64
+
+		// Uniform point in a disc of radius s.Meters
+		r := s.Meters * math.Sqrt(u1)
+		theta := 2 * math.Pi * u2
+		lat += r * math.Cos(theta) / metersPerDegree
+		lat = math.Max(-90, math.Min(90, lat))
+		lon += r * math.Sin(theta) / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	default:
+		return lat, lon
+	}
+
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+	return lat, lon
+}
+
+// publicLocation applies s unless the viewer is the user themselves.
+func publicLocation(viewer, username string, lat, lon float64, s privacySetting) (float64, float64) {
+	if viewer == username {
+		return lat, lon
+	}
+	return fuzzLocation(username, lat, lon, s)
+}
+
+func getPrivacySetting(ctx context.Context, q querier, username string) (privacySetting, error) {
+	s := privacySetting{Mode: precisionExact}
+	err := q.QueryRowContext(ctx, "SELECT precision_mode, precision_meters FROM user_settings WHERE username = $1",
+		username).Scan(&s.Mode, &s.Meters)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
+
+// getPrivacy handles GET /users/:username/privacy.
+// Requires the caller to be the user, or to hold the admin scope.
+func getPrivacy(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	s, err := getPrivacySetting(c.Request.Context(), db.DB, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "mode": s.Mode, "meters": s.Meters})
+}
+
+// updatePrivacy handles PUT /users/:username/privacy.
+// Requires the caller to be the user, or to hold the admin scope.
+func updatePrivacy(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+
+	var request privacySetting
+	if err := c.ShouldBindJSON(&request); err != nil {
+		invalidBody(c, err)
+		return
+	}
+	var v validation.Validator
+	v.OneOf("mode", request.Mode, precisionExact, precisionGrid, precisionOffset)
+	if request.Mode != precisionExact && request.Meters == 0 {
+		// grid and offset need a size
+		v.Positive("meters", &request.Meters)
+	} else {
+		v.Between("meters", request.Meters, 0, maxPrecisionMeters)
+	}
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	_, err := db.DB.ExecContext(c.Request.Context(), `
+        INSERT INTO user_settings (username, precision_mode, precision_meters)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (username) DO UPDATE
+        SET precision_mode = EXCLUDED.precision_mode, precision_meters = EXCLUDED.precision_meters`,
+		username, request.Mode, request.Meters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update privacy settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "mode": request.Mode, "meters": request.Meters})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzLocationGrid(t *testing.T) {
+	s := privacySetting{Mode: precisionGrid, Meters: 1000}
+
+	lat, lon := fuzzLocation("testuser", 37.7749, -122.4194, s)
+	assert.LessOrEqual(t, CalculateDistance(37.7749, -122.4194, lat, lon), 1.0)
+
+	// Nearby points in the same cell snap to the same position
+	lat2, lon2 := fuzzLocation("testuser", 37.7750, -122.4195, s)
+	assert.Equal(t, lat, lat2)
+	assert.Equal(t, lon, lon2)
+}
+
+func TestFuzzLocationOffset(t *testing.T) {
+	privacySecret = []byte("test-secret")
+	s := privacySetting{Mode: precisionOffset, Meters: 500}
+
+	lat, lon := fuzzLocation("testuser", 37.7749, -122.4194, s)
+	assert.LessOrEqual(t, CalculateDistance(37.7749, -122.4194, lat, lon), 0.5)
+	assert.NotEqual(t, 37.7749, lat)
+
+	// The offset is stable for a user
+	lat2, lon2 := fuzzLocation("testuser", 37.7749, -122.4194, s)
+	assert.Equal(t, lat, lat2)
+	assert.Equal(t, lon, lon2)
+
+	// and differs between users
+	lat3, _ := fuzzLocation("otheruser", 37.7749, -122.4194, s)
+	assert.NotEqual(t, lat, lat3)
+}
+
+func TestPublicLocation(t *testing.T) {
+	s := privacySetting{Mode: precisionGrid, Meters: 1000}
+
+	lat, lon := publicLocation("testuser", "testuser", 37.7749, -122.4194, s)
+	assert.Equal(t, 37.7749, lat)
+	assert.Equal(t, -122.4194, lon)
+
+	lat, _ = publicLocation("otheruser", "testuser", 37.7749, -122.4194, s)
+	assert.NotEqual(t, 37.7749, lat)
+
+	lat, _ = publicLocation("otheruser", "testuser", 37.7749, -122.4194, privacySetting{Mode: precisionExact})
+	assert.Equal(t, 37.7749, lat)
+}
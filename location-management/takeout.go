@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+)
+
+const (
+	takeoutRecords  = "records"
+	takeoutSemantic = "semantic"
+)
+
+var errTakeoutFormat = errors.New("not a Records.json or Semantic Location History file")
+
+// e7 converts degrees times 10^7, as Google exports coordinates. Some
+// exports wrapped values past the int32 range around to negative ones;
+// those are undone here.
+func e7(v int64) float64 {
+	if v > math.MaxInt32 {
+		v -= 1 << 32
+	}
+	return float64(v) / 1e7
+}
+
+// takeoutTime parses an RFC 3339 timestamp, or the millisecond timestampMs
+// strings of older exports, to Unix seconds.
+func takeoutTime(timestamp, timestampMs string) (int64, error) {
+	if timestamp != "" {
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		return t.Unix(), nil
+	}
+	if timestampMs != "" {
+		ms, err := strconv.ParseInt(timestampMs, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestampMs %q", timestampMs)
+		}
+		return ms / 1000, nil
+	}
+	return 0, errors.New("missing timestamp")
+}
+
+// takeoutPoint converts coordinates and a time into a location.
+func takeoutPoint(lat, lon *int64, timestamp, timestampMs string) (*pb.ImportedLocation, error) {
+	if lat == nil || lon == nil {
+		return nil, errors.New("missing coordinates")
+	}
+	ts, err := takeoutTime(timestamp, timestampMs)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ImportedLocation{Latitude: e7(*lat), Longitude: e7(*lon), Timestamp: ts}, nil
+}
+
+// takeoutRecord is an element of "locations" in Records.json.
+type takeoutRecord struct {
+	LatitudeE7  *int64 `json:"latitudeE7"`
+	LongitudeE7 *int64 `json:"longitudeE7"`
+	Timestamp   string `json:"timestamp"`
+	TimestampMs string `json:"timestampMs"`
+	// Meters.
+	Accuracy *float64 `json:"accuracy"`
+	Altitude *float64 `json:"altitude"`
+	// Meters per second.
+	Velocity *float64 `json:"velocity"`
+}
+
+func (r takeoutRecord) points() ([]*pb.ImportedLocation, error) {
+	p, err := takeoutPoint(r.LatitudeE7, r.LongitudeE7, r.Timestamp, r.TimestampMs)
+	if err != nil {
+		return nil, err
+	}
+	p.Accuracy, p.Altitude, p.Speed = r.Accuracy, r.Altitude, r.Velocity
+	return []*pb.ImportedLocation{p}, nil
+}
+
+type takeoutLocation struct {
+	LatitudeE7  *int64 `json:"latitudeE7"`
+	LongitudeE7 *int64 `json:"longitudeE7"`
+}
+
+type takeoutDuration struct {
+	StartTimestamp   string `json:"startTimestamp"`
+	StartTimestampMs string `json:"startTimestampMs"`
+	EndTimestamp     string `json:"endTimestamp"`
+	EndTimestampMs   string `json:"endTimestampMs"`
+}
+
+// takeoutTimelineObject is an element of "timelineObjects" in a monthly
+// Semantic Location History file: either a place visit or an activity
+// segment between two places.
+type takeoutTimelineObject struct {
+	PlaceVisit *struct {
+		Location takeoutLocation `json:"location"`
+		Duration takeoutDuration `json:"duration"`
+	} `json:"placeVisit"`
+	ActivitySegment *struct {
+		StartLocation     takeoutLocation `json:"startLocation"`
+		EndLocation       takeoutLocation `json:"endLocation"`
+		Duration          takeoutDuration `json:"duration"`
+		SimplifiedRawPath *struct {
+			Points []struct {
+				LatE7       *int64 `json:"latE7"`
+				LngE7       *int64 `json:"lngE7"`
+				Timestamp   string `json:"timestamp"`
+				TimestampMs string `json:"timestampMs"`
+			} `json:"points"`
+		} `json:"simplifiedRawPath"`
+	} `json:"activitySegment"`
+}
+
+// points returns a visit's place at its start and end, and a segment's
+// start, timed raw path points and end.
+func (o takeoutTimelineObject) points() ([]*pb.ImportedLocation, error) {
+	var points []*pb.ImportedLocation
+	add := func(lat, lon *int64, timestamp, timestampMs string) error {
+		p, err := takeoutPoint(lat, lon, timestamp, timestampMs)
+		if err != nil {
+			return err
+		}
+		points = append(points, p)
+		return nil
+	}
+
+	switch {
+	case o.PlaceVisit != nil:
+		v := o.PlaceVisit
+		if err := add(v.Location.LatitudeE7, v.Location.LongitudeE7, v.Duration.StartTimestamp, v.Duration.StartTimestampMs); err != nil {
+			return nil, fmt.Errorf("placeVisit: %w", err)
+		}
+		if err := add(v.Location.LatitudeE7, v.Location.LongitudeE7, v.Duration.EndTimestamp, v.Duration.EndTimestampMs); err != nil {
+			return nil, fmt.Errorf("placeVisit: %w", err)
+		}
+	case o.ActivitySegment != nil:
+		s := o.ActivitySegment
+		if err := add(s.StartLocation.LatitudeE7, s.StartLocation.LongitudeE7, s.Duration.StartTimestamp, s.Duration.StartTimestampMs); err != nil {
+			return nil, fmt.Errorf("activitySegment: %w", err)
+		}
+		if s.SimplifiedRawPath != nil {
+			for _, p := range s.SimplifiedRawPath.Points {
+				if err := add(p.LatE7, p.LngE7, p.Timestamp, p.TimestampMs); err != nil {
+					return nil, fmt.Errorf("activitySegment: %w", err)
+				}
+			}
+		}
+		if err := add(s.EndLocation.LatitudeE7, s.EndLocation.LongitudeE7, s.Duration.EndTimestamp, s.Duration.EndTimestampMs); err != nil {
+			return nil, fmt.Errorf("activitySegment: %w", err)
+		}
+	}
+	return points, nil
+}
+
+// takeoutItem is one element of an export and the locations it holds. Err
+// is set when the element cannot be converted; the rest of the file is
+// still read.
+type takeoutItem struct {
+	Path   string
+	Points []*pb.ImportedLocation
+	Err    error
+}
+
+// readTakeout streams a Records.json or Semantic Location History file
+// without loading it into memory, calling item for each element. It returns
+// the detected format, or an error for malformed JSON, unknown files or an
+// error returned by item.
+func readTakeout(r io.Reader, item func(takeoutItem) error) (string, error) {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "", errTakeoutFormat
+	}
+
+	format := ""
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return format, err
+		}
+		key, _ := t.(string)
+		var read func(i int) takeoutItem
+		switch key {
+		case "locations":
+			format = takeoutRecords
+			read = func(i int) takeoutItem {
+				var rec takeoutRecord
+				it := takeoutItem{Path: fmt.Sprintf("locations[%d]", i)}
+				if it.Err = dec.Decode(&rec); it.Err == nil {
+					it.Points, it.Err = rec.points()
+				}
+				return it
+			}
+		case "timelineObjects":
+			format = takeoutSemantic
+			read = func(i int) takeoutItem {
+				var obj takeoutTimelineObject
+				it := takeoutItem{Path: fmt.Sprintf("timelineObjects[%d]", i)}
+				if it.Err = dec.Decode(&obj); it.Err == nil {
+					it.Points, it.Err = obj.points()
+				}
+				return it
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return format, err
+			}
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return format, fmt.Errorf("%s is not an array", key)
+		}
+		for i := 0; dec.More(); i++ {
+			it := read(i)
+			var syntax *json.SyntaxError
+			if errors.As(it.Err, &syntax) || errors.Is(it.Err, io.ErrUnexpectedEOF) {
+				return format, it.Err
+			}
+			if err := item(it); err != nil {
+				return format, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return format, err
+		}
+	}
+	if format == "" {
+		return "", errTakeoutFormat
+	}
+	return format, nil
+}
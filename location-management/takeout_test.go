@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/abotoiGrid/Golang-Project/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(t *testing.T, data string) (string, []takeoutItem, error) {
+	t.Helper()
+	var items []takeoutItem
+	format, err := readTakeout(strings.NewReader(data), func(it takeoutItem) error {
+		items = append(items, it)
+		return nil
+	})
+	return format, items, err
+}
+
+func TestTakeoutE7(t *testing.T) {
+	assert.Equal(t, 44.4268, e7(444268000))
+	assert.Equal(t, -26.1025, e7(-261025000))
+	// 250 degrees wrapped past the int32 range by an old export
+	assert.InDelta(t, -179.4967296, e7(2500000000), 1e-9)
+}
+
+func TestTakeoutRecords(t *testing.T) {
+	format, items, err := readAll(t, `{"locations": [
+		{"latitudeE7": 444268000, "longitudeE7": 261025000, "accuracy": 12, "altitude": 80, "velocity": 3,
+		 "activity": [{"type": "WALKING"}], "timestamp": "2021-03-31T11:06:05.123Z"},
+		{"latitudeE7": 444269000, "longitudeE7": 261026000, "timestampMs": "1617188825000"},
+		{"latitudeE7": 444269000, "timestamp": "2021-03-31T11:08:05Z"},
+		{"latitudeE7": 444269000, "longitudeE7": 261026000, "timestamp": "yesterday"}
+	]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, takeoutRecords, format)
+	assert.Len(t, items, 4)
+
+	accuracy, altitude, speed := 12.0, 80.0, 3.0
+	assert.Equal(t, []*pb.ImportedLocation{{
+		Latitude: 44.4268, Longitude: 26.1025, Timestamp: 1617188765,
+		Accuracy: &accuracy, Altitude: &altitude, Speed: &speed,
+	}}, items[0].Points)
+	assert.Equal(t, []*pb.ImportedLocation{{Latitude: 44.4269, Longitude: 26.1026, Timestamp: 1617188825}}, items[1].Points)
+	assert.Equal(t, "locations[2]", items[2].Path)
+	assert.EqualError(t, items[2].Err, "missing coordinates")
+	assert.EqualError(t, items[3].Err, `invalid timestamp "yesterday"`)
+}
+
+func TestTakeoutSemantic(t *testing.T) {
+	format, items, err := readAll(t, `{"timelineObjects": [
+		{"placeVisit": {
+			"location": {"latitudeE7": 444268000, "longitudeE7": 261025000, "name": "Home"},
+			"duration": {"startTimestamp": "2021-03-31T08:00:00Z", "endTimestamp": "2021-03-31T09:00:00Z"}}},
+		{"activitySegment": {
+			"startLocation": {"latitudeE7": 444268000, "longitudeE7": 261025000},
+			"endLocation": {"latitudeE7": 444300000, "longitudeE7": 261100000},
+			"duration": {"startTimestampMs": "1617181200000", "endTimestampMs": "1617182100000"},
+			"simplifiedRawPath": {"points": [{"latE7": 444280000, "lngE7": 261050000, "timestampMs": "1617181500000"}]}}},
+		{"placeVisit": {"location": {"placeId": "ChIJ"}, "duration": {"startTimestamp": "2021-03-31T10:00:00Z"}}}
+	]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, takeoutSemantic, format)
+	assert.Len(t, items, 3)
+
+	assert.Equal(t, []*pb.ImportedLocation{
+		{Latitude: 44.4268, Longitude: 26.1025, Timestamp: 1617177600},
+		{Latitude: 44.4268, Longitude: 26.1025, Timestamp: 1617181200},
+	}, items[0].Points)
+	assert.Equal(t, []*pb.ImportedLocation{
+		{Latitude: 44.4268, Longitude: 26.1025, Timestamp: 1617181200},
+		{Latitude: 44.428, Longitude: 26.105, Timestamp: 1617181500},
+		{Latitude: 44.43, Longitude: 26.11, Timestamp: 1617182100},
+	}, items[1].Points)
+	assert.Equal(t, "timelineObjects[2]", items[2].Path)
+	assert.EqualError(t, items[2].Err, "placeVisit: missing coordinates")
+}
+
+func TestTakeoutInvalidFiles(t *testing.T) {
+	for _, data := range []string{`[]`, `{"other": [1, 2]}`, `not json`} {
+		_, _, err := readAll(t, data)
+		assert.ErrorIs(t, err, errTakeoutFormat, data)
+	}
+
+	_, _, err := readAll(t, `{"locations": {}}`)
+	assert.EqualError(t, err, "locations is not an array")
+
+	// A truncated file stops the import instead of being skipped element
+	// by element.
+	_, items, err := readAll(t, `{"locations": [{"latitudeE7": 1, "longitudeE7": 1, "timestamp": "2021-03-31T11:06:05Z"}, {"latitudeE7": 4`)
+	assert.Error(t, err)
+	assert.Len(t, items, 1)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// invalidRequest responds 400 with the combined message and, for
+// validation errors, the individual invalid fields.
+func invalidRequest(c *gin.Context, err error) {
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errs.Error(), "fields": errs})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// invalidBody responds 400 to a JSON body that could not be decoded, naming
+// the field when a value has the wrong type.
+func invalidBody(c *gin.Context, err error) {
+	fe := validation.FieldError{Field: "body", Message: "Invalid request body. Must be a JSON object"}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fe = validation.FieldError{Field: typeErr.Field, Message: fmt.Sprintf("Invalid %s. Must be %s", typeErr.Field, jsonKind(typeErr.Type))}
+	}
+	invalidRequest(c, validation.Errors{fe})
+}
+
+// jsonKind describes the JSON value that decodes into t.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// queryFloat returns the named query parameter, or nil when it is missing
+// or not a number so that the range checks report it.
+func queryFloat(c *gin.Context, name string) *float64 {
+	f, err := strconv.ParseFloat(c.Query(name), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// validUsernameParams checks that the named path parameters are valid
+// usernames and responds 400 otherwise.
+func validUsernameParams(c *gin.Context, params ...string) bool {
+	var v validation.Validator
+	for _, p := range params {
+		v.Username(p, c.Param(p))
+	}
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type validationResponse struct {
+	Error  string                  `json:"error"`
+	Fields []validation.FieldError `json:"fields"`
+}
+
+func doValidationRequest(t *testing.T, r *gin.Engine, method, path, body string) validationResponse {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp validationResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestValidationErrors(t *testing.T) {
+	r := gin.New()
+	r.POST("/location/update", withPrincipal("testuser"), UpdateLocation)
+	r.GET("/users/search", withPrincipal("testuser"), searchUsers)
+	r.GET("/users/nearest", withPrincipal("testuser"), nearestUsers)
+	r.GET("/users/distance", withPrincipal("testuser"), CalculateTravelDistance)
+	r.PUT("/users/:username/privacy", withPrincipal("testuser"), updatePrivacy)
+	r.PUT("/users/:username/visibility", withPrincipal("testuser"), updateUserVisibility)
+	r.POST("/admin/api-keys", withPrincipal("admin", scopeAdmin), newAPIKeyStore(1, 1).createAPIKey)
+	r.GET("/users/:username/contacts/:contact", withPrincipal("testuser"), func(c *gin.Context) {
+		validUsernameParams(c, "username", "contact")
+	})
+
+	// Latitude is limited to ±90, longitude to ±180
+	resp := doValidationRequest(t, r, "POST", "/location/update",
+		`{"username": "testuser", "latitude": 100, "longitude": 170}`)
+	assert.Equal(t, validation.MsgLatitude, resp.Error)
+	assert.Equal(t, []validation.FieldError{{Field: "latitude", Message: validation.MsgLatitude}}, resp.Fields)
+
+	// Every invalid field is reported
+	resp = doValidationRequest(t, r, "POST", "/location/update", `{"username": "x"}`)
+	assert.Len(t, resp.Fields, 3)
+
+	resp = doValidationRequest(t, r, "GET", "/users/search?latitude=0&longitude=0&radius=0", "")
+	assert.Equal(t, "radius", resp.Fields[0].Field)
+
+	resp = doValidationRequest(t, r, "GET", "/users/nearest?longitude=0", "")
+	assert.Equal(t, "latitude", resp.Fields[0].Field)
+
+	resp = doValidationRequest(t, r, "GET", "/users/nearest?latitude=0&longitude=0&limit=ten&scope=friends", "")
+	assert.Equal(t, []validation.FieldError{
+		{Field: "limit", Message: "Invalid limit. Must be at least 1"},
+		{Field: "scope", Message: "Invalid scope. Must be one of all, contacts"},
+	}, resp.Fields)
+
+	// grid and offset need a size
+	resp = doValidationRequest(t, r, "PUT", "/users/testuser/privacy", `{"mode": "grid"}`)
+	assert.Equal(t, []validation.FieldError{{Field: "meters", Message: "Invalid meters. Must be greater than 0"}}, resp.Fields)
+
+	// Values of the wrong type name their field
+	resp = doValidationRequest(t, r, "PUT", "/users/testuser/privacy", `{"mode": "grid", "meters": "far"}`)
+	assert.Equal(t, []validation.FieldError{{Field: "meters", Message: "Invalid meters. Must be a number"}}, resp.Fields)
+
+	resp = doValidationRequest(t, r, "PUT", "/users/testuser/visibility", `{"visibility": "friends"}`)
+	assert.Equal(t, "visibility", resp.Fields[0].Field)
+
+	resp = doValidationRequest(t, r, "POST", "/admin/api-keys", `{"username": "testuser", "burst": -1}`)
+	assert.Equal(t, []validation.FieldError{
+		{Field: "name", Message: "Invalid name. Must not be empty"},
+		{Field: "burst", Message: "Invalid burst. Must be at least 0"},
+	}, resp.Fields)
+
+	resp = doValidationRequest(t, r, "POST", "/admin/api-keys", `[]`)
+	assert.Equal(t, "body", resp.Fields[0].Field)
+
+	resp = doValidationRequest(t, r, "GET",
+		"/users/distance?username=testuser&start=2023-01-02T00:00:00Z&end=2023-01-01T00:00:00Z", "")
+	assert.Equal(t, validation.MsgTimeRange, resp.Error)
+
+	// An end alone must still leave a valid range
+	resp = doValidationRequest(t, r, "GET", "/users/distance?username=test@user&end=2023-01-01T00:00:00Z", "")
+	assert.Equal(t, []validation.FieldError{{Field: "username", Message: validation.MsgUsername}}, resp.Fields)
+
+	resp = doValidationRequest(t, r, "GET", "/users/testuser/contacts/a-b", "")
+	assert.Equal(t, "contact", resp.Fields[0].Field)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const versionUnversioned = "unversioned"
+
+// legacyDeprecatedAt is when /v1 was introduced and the unversioned routes
+// became deprecated aliases of it.
+var legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// legacySunset is when the unversioned routes may be removed. It is zero
+// until legacy_sunset is configured.
+var legacySunset time.Time
+
+// apiVersion counts requests to the routes of version. The unversioned
+// routes also announce their deprecation (RFC 9745) and, once configured,
+// their sunset date (RFC 8594).
+func apiVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiVersionRequests.WithLabelValues(version).Inc()
+		if version == versionUnversioned {
+			c.Header("Deprecation", "@"+strconv.FormatInt(legacyDeprecatedAt.Unix(), 10))
+			if !legacySunset.IsZero() {
+				c.Header("Sunset", legacySunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		c.Next()
+	}
+}
+
+// registerAPI registers the routes of one API version on g. gateway serves
+// the LocationService HTTP annotations; the unversioned group passes nil,
+// keeps the compatibility routes those annotations replaced and gets no new
+// routes.
+// registerLive registers the live feeds of one API version on g, which
+// authenticates with streamMiddleware.
+func registerLive(g *gin.RouterGroup) {
+	g.GET("/live", func(c *gin.Context) { liveFeed.serve(c) })
+	g.GET("/users/:username/live", func(c *gin.Context) { liveFeed.streamUser(c) })
+}
+
+func registerAPI(g *gin.RouterGroup, auth *authenticator, gateway gin.HandlerFunc) {
+	if gateway != nil {
+		g.POST("/locations", gateway)
+		g.GET("/users/search", gateway)
+		g.GET("/users/:username/distance", gateway)
+		g.GET("/retention", gateway)
+		g.PUT("/users/:username/retention", gateway)
+		g.DELETE("/users/:username/retention", gateway)
+		g.POST("/users/:username/devices", gateway)
+		g.GET("/users/:username/devices", gateway)
+		g.DELETE("/users/:username/devices/:device_id", gateway)
+		g.POST("/owntracks", owntracks)
+		g.POST("/users/:username/imports", func(c *gin.Context) { imports.createImport(c) })
+		g.GET("/users/:username/imports", listImports)
+		g.GET("/users/:username/imports/:id", getImport)
+	} else {
+		g.POST("/location/update", UpdateLocation)
+		g.GET("/users/search", searchUsers)
+		g.GET("/users/distance", CalculateTravelDistance)
+	}
+
+	g.GET("/users/nearest", nearestUsers)
+	g.GET("/users/:username/privacy", getPrivacy)
+	g.PUT("/users/:username/privacy", updatePrivacy)
+	g.GET("/users/:username/visibility", getUserVisibility)
+	g.PUT("/users/:username/visibility", updateUserVisibility)
+	g.GET("/users/:username/contacts", getContacts)
+	g.POST("/users/:username/contacts", sendContactRequest)
+	g.POST("/users/:username/contacts/:contact/accept", acceptContactRequest)
+	g.DELETE("/users/:username/contacts/:contact", removeContact)
+	g.GET("/users/:username/export", exportUser)
+	g.DELETE("/users/:username", eraseUser)
+
+	admin := g.Group("/admin", requireScope(scopeAdmin))
+	admin.POST("/api-keys", auth.apiKeys.createAPIKey)
+	admin.GET("/api-keys", auth.apiKeys.listAPIKeys)
+	admin.DELETE("/api-keys/:id", auth.apiKeys.revokeAPIKey)
+}
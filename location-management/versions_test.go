@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersions(t *testing.T) {
+	router := newRouter(&authenticator{apiKeys: newAPIKeyStore(1, 1)})
+	legacySunset = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+	defer func() { legacySunset = time.Time{} }()
+
+	for version, path := range map[string]string{
+		versionUnversioned: "/users/testuser/privacy",
+		"v1":               "/v1/users/testuser/privacy",
+		"v2":               "/v2/users/testuser/privacy",
+	} {
+		before := testutil.ToFloat64(apiVersionRequests.WithLabelValues(version))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		// Counted even when authentication fails
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+		assert.Equal(t, before+1, testutil.ToFloat64(apiVersionRequests.WithLabelValues(version)), path)
+
+		if version == versionUnversioned {
+			assert.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+			assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+		} else {
+			assert.Empty(t, w.Header().Get("Deprecation"), path)
+			assert.Empty(t, w.Header().Get("Sunset"), path)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abotoiGrid/Golang-Project/db"
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	visibilityPublic    = "public"
+	visibilityContacts  = "contacts"
+	visibilityInvisible = "invisible"
+)
+
+// visibleToViewer returns a SQL condition that is true when the location
+// row aliased loc, joined with its user_settings row aliased settings, may
+// be returned to the viewer bound at parameter viewerParam. Every search
+// path must include it.
+func visibleToViewer(loc, settings string, viewerParam int) string {
+	return fmt.Sprintf(`(%[1]s.username = $%[3]d
+            OR COALESCE(%[2]s.visibility, 'public') = 'public'
+            OR (%[2]s.visibility = 'contacts' AND %[4]s))`,
+		loc, settings, viewerParam, isContactOf(loc+".username", viewerParam))
+}
+
+// visibleTo reports whether viewer may find username, by the same rule as
+// search.
+func visibleTo(ctx context.Context, viewer, username string) (bool, error) {
+	var visible bool
+	err := db.DB.QueryRowContext(ctx, `
+        SELECT `+visibleToViewer("l", "s", 2)+`
+        FROM (SELECT $1::text AS username) l
+        LEFT JOIN user_settings s ON s.username = l.username`,
+		username, viewer).Scan(&visible)
+	return visible, err
+}
+
+func getVisibility(ctx context.Context, q querier, username string) (string, error) {
+	visibility := visibilityPublic
+	err := q.QueryRowContext(ctx, "SELECT visibility FROM user_settings WHERE username = $1", username).Scan(&visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return visibilityPublic, nil
+	}
+	return visibility, err
+}
+
+// getUserVisibility handles GET /users/:username/visibility.
+// Requires the caller to be the user, or to hold the admin scope.
+func getUserVisibility(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	visibility, err := getVisibility(c.Request.Context(), db.DB, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "visibility": visibility})
+}
+
+// updateUserVisibility handles PUT /users/:username/visibility.
+// Requires the caller to be the user, or to hold the admin scope.
+func updateUserVisibility(c *gin.Context) {
+	username := c.Param("username")
+	if !validUsernameParams(c, "username") {
+		return
+	}
+
+	var request struct {
+		Visibility string `json:"visibility"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		invalidBody(c, err)
+		return
+	}
+	var v validation.Validator
+	v.OneOf("visibility", request.Visibility, visibilityPublic, visibilityContacts, visibilityInvisible)
+	if err := v.Err(); err != nil {
+		invalidRequest(c, err)
+		return
+	}
+
+	if !authorizeUser(c, username, scopeAdmin) {
+		return
+	}
+
+	_, err := db.DB.ExecContext(c.Request.Context(), `
+        INSERT INTO user_settings (username, visibility)
+        VALUES ($1, $2)
+        ON CONFLICT (username) DO UPDATE SET visibility = EXCLUDED.visibility`,
+		username, request.Visibility)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update visibility"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "visibility": request.Visibility})
+}
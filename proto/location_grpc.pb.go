@@ -19,14 +19,55 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	LocationService_UpdateLocation_FullMethodName = "/location.LocationService/UpdateLocation"
+	LocationService_UpdateLocation_FullMethodName          = "/location.LocationService/UpdateLocation"
+	LocationService_SearchUsers_FullMethodName             = "/location.LocationService/SearchUsers"
+	LocationService_GetTravelDistance_FullMethodName       = "/location.LocationService/GetTravelDistance"
+	LocationService_GetRetentionStatus_FullMethodName      = "/location.LocationService/GetRetentionStatus"
+	LocationService_SetRetentionOverride_FullMethodName    = "/location.LocationService/SetRetentionOverride"
+	LocationService_DeleteRetentionOverride_FullMethodName = "/location.LocationService/DeleteRetentionOverride"
+	LocationService_EraseUser_FullMethodName               = "/location.LocationService/EraseUser"
+	LocationService_ExportUser_FullMethodName              = "/location.LocationService/ExportUser"
+	LocationService_ImportLocations_FullMethodName         = "/location.LocationService/ImportLocations"
+	LocationService_RegisterDevice_FullMethodName          = "/location.LocationService/RegisterDevice"
+	LocationService_ListDevices_FullMethodName             = "/location.LocationService/ListDevices"
+	LocationService_GetDevice_FullMethodName               = "/location.LocationService/GetDevice"
+	LocationService_DeleteDevice_FullMethodName            = "/location.LocationService/DeleteDevice"
 )
 
 // LocationServiceClient is the client API for LocationService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LocationService is implemented by location-history, which stores history,
+// and by location-management, which authenticates users, applies their
+// privacy settings and serves the HTTP annotations below through
+// grpc-gateway. SearchUsers and GetTravelDistance are only answered by
+// location-management. EraseUser has no annotation because location-management
+// serves DELETE /v1/users/{username} itself to sign a deletion receipt, and
+// ExportUser because it is part of GET /v1/users/{username}/export.
 type LocationServiceClient interface {
 	UpdateLocation(ctx context.Context, in *LocationRequest, opts ...grpc.CallOption) (*LocationResponse, error)
+	SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error)
+	GetTravelDistance(ctx context.Context, in *TravelDistanceRequest, opts ...grpc.CallOption) (*TravelDistanceResponse, error)
+	GetRetentionStatus(ctx context.Context, in *RetentionStatusRequest, opts ...grpc.CallOption) (*RetentionStatusResponse, error)
+	// SetRetentionOverride creates or replaces the user's override.
+	SetRetentionOverride(ctx context.Context, in *RetentionOverride, opts ...grpc.CallOption) (*RetentionOverride, error)
+	// DeleteRetentionOverride returns the user to the default retention.
+	DeleteRetentionOverride(ctx context.Context, in *DeleteRetentionOverrideRequest, opts ...grpc.CallOption) (*DeleteRetentionOverrideResponse, error)
+	EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*EraseUserResponse, error)
+	// ExportUser streams everything EraseUser deletes.
+	ExportUser(ctx context.Context, in *ExportUserRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportUserChunk], error)
+	// ImportLocations bulk-loads past history. It is idempotent, so a failed
+	// batch can be sent again. location-management calls it from import jobs.
+	ImportLocations(ctx context.Context, in *ImportLocationsRequest, opts ...grpc.CallOption) (*ImportLocationsResponse, error)
+	// RegisterDevice is idempotent for the owning user. A device ID belongs
+	// to at most one user.
+	RegisterDevice(ctx context.Context, in *RegisterDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// GetDevice looks up the owner of a device for location-management's
+	// tracker endpoints. It has no annotation, so it is not exposed over HTTP.
+	GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	DeleteDevice(ctx context.Context, in *DeleteDeviceRequest, opts ...grpc.CallOption) (*DeleteDeviceResponse, error)
 }
 
 type locationServiceClient struct {
@@ -47,11 +88,169 @@ func (c *locationServiceClient) UpdateLocation(ctx context.Context, in *Location
 	return out, nil
 }
 
+func (c *locationServiceClient) SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchUsersResponse)
+	err := c.cc.Invoke(ctx, LocationService_SearchUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) GetTravelDistance(ctx context.Context, in *TravelDistanceRequest, opts ...grpc.CallOption) (*TravelDistanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TravelDistanceResponse)
+	err := c.cc.Invoke(ctx, LocationService_GetTravelDistance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) GetRetentionStatus(ctx context.Context, in *RetentionStatusRequest, opts ...grpc.CallOption) (*RetentionStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RetentionStatusResponse)
+	err := c.cc.Invoke(ctx, LocationService_GetRetentionStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) SetRetentionOverride(ctx context.Context, in *RetentionOverride, opts ...grpc.CallOption) (*RetentionOverride, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RetentionOverride)
+	err := c.cc.Invoke(ctx, LocationService_SetRetentionOverride_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) DeleteRetentionOverride(ctx context.Context, in *DeleteRetentionOverrideRequest, opts ...grpc.CallOption) (*DeleteRetentionOverrideResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteRetentionOverrideResponse)
+	err := c.cc.Invoke(ctx, LocationService_DeleteRetentionOverride_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*EraseUserResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EraseUserResponse)
+	err := c.cc.Invoke(ctx, LocationService_EraseUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) ExportUser(ctx context.Context, in *ExportUserRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportUserChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LocationService_ServiceDesc.Streams[0], LocationService_ExportUser_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportUserRequest, ExportUserChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_ExportUserClient = grpc.ServerStreamingClient[ExportUserChunk]
+
+func (c *locationServiceClient) ImportLocations(ctx context.Context, in *ImportLocationsRequest, opts ...grpc.CallOption) (*ImportLocationsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImportLocationsResponse)
+	err := c.cc.Invoke(ctx, LocationService_ImportLocations_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) RegisterDevice(ctx context.Context, in *RegisterDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, LocationService_RegisterDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, LocationService_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, LocationService_GetDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *locationServiceClient) DeleteDevice(ctx context.Context, in *DeleteDeviceRequest, opts ...grpc.CallOption) (*DeleteDeviceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteDeviceResponse)
+	err := c.cc.Invoke(ctx, LocationService_DeleteDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LocationServiceServer is the server API for LocationService service.
 // All implementations must embed UnimplementedLocationServiceServer
 // for forward compatibility.
+//
+// LocationService is implemented by location-history, which stores history,
+// and by location-management, which authenticates users, applies their
+// privacy settings and serves the HTTP annotations below through
+// grpc-gateway. SearchUsers and GetTravelDistance are only answered by
+// location-management. EraseUser has no annotation because location-management
+// serves DELETE /v1/users/{username} itself to sign a deletion receipt, and
+// ExportUser because it is part of GET /v1/users/{username}/export.
 type LocationServiceServer interface {
 	UpdateLocation(context.Context, *LocationRequest) (*LocationResponse, error)
+	SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error)
+	GetTravelDistance(context.Context, *TravelDistanceRequest) (*TravelDistanceResponse, error)
+	GetRetentionStatus(context.Context, *RetentionStatusRequest) (*RetentionStatusResponse, error)
+	// SetRetentionOverride creates or replaces the user's override.
+	SetRetentionOverride(context.Context, *RetentionOverride) (*RetentionOverride, error)
+	// DeleteRetentionOverride returns the user to the default retention.
+	DeleteRetentionOverride(context.Context, *DeleteRetentionOverrideRequest) (*DeleteRetentionOverrideResponse, error)
+	EraseUser(context.Context, *EraseUserRequest) (*EraseUserResponse, error)
+	// ExportUser streams everything EraseUser deletes.
+	ExportUser(*ExportUserRequest, grpc.ServerStreamingServer[ExportUserChunk]) error
+	// ImportLocations bulk-loads past history. It is idempotent, so a failed
+	// batch can be sent again. location-management calls it from import jobs.
+	ImportLocations(context.Context, *ImportLocationsRequest) (*ImportLocationsResponse, error)
+	// RegisterDevice is idempotent for the owning user. A device ID belongs
+	// to at most one user.
+	RegisterDevice(context.Context, *RegisterDeviceRequest) (*Device, error)
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// GetDevice looks up the owner of a device for location-management's
+	// tracker endpoints. It has no annotation, so it is not exposed over HTTP.
+	GetDevice(context.Context, *GetDeviceRequest) (*Device, error)
+	DeleteDevice(context.Context, *DeleteDeviceRequest) (*DeleteDeviceResponse, error)
 	mustEmbedUnimplementedLocationServiceServer()
 }
 
@@ -65,6 +264,42 @@ type UnimplementedLocationServiceServer struct{}
 func (UnimplementedLocationServiceServer) UpdateLocation(context.Context, *LocationRequest) (*LocationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateLocation not implemented")
 }
+func (UnimplementedLocationServiceServer) SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchUsers not implemented")
+}
+func (UnimplementedLocationServiceServer) GetTravelDistance(context.Context, *TravelDistanceRequest) (*TravelDistanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTravelDistance not implemented")
+}
+func (UnimplementedLocationServiceServer) GetRetentionStatus(context.Context, *RetentionStatusRequest) (*RetentionStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRetentionStatus not implemented")
+}
+func (UnimplementedLocationServiceServer) SetRetentionOverride(context.Context, *RetentionOverride) (*RetentionOverride, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRetentionOverride not implemented")
+}
+func (UnimplementedLocationServiceServer) DeleteRetentionOverride(context.Context, *DeleteRetentionOverrideRequest) (*DeleteRetentionOverrideResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRetentionOverride not implemented")
+}
+func (UnimplementedLocationServiceServer) EraseUser(context.Context, *EraseUserRequest) (*EraseUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EraseUser not implemented")
+}
+func (UnimplementedLocationServiceServer) ExportUser(*ExportUserRequest, grpc.ServerStreamingServer[ExportUserChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportUser not implemented")
+}
+func (UnimplementedLocationServiceServer) ImportLocations(context.Context, *ImportLocationsRequest) (*ImportLocationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportLocations not implemented")
+}
+func (UnimplementedLocationServiceServer) RegisterDevice(context.Context, *RegisterDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterDevice not implemented")
+}
+func (UnimplementedLocationServiceServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedLocationServiceServer) GetDevice(context.Context, *GetDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDevice not implemented")
+}
+func (UnimplementedLocationServiceServer) DeleteDevice(context.Context, *DeleteDeviceRequest) (*DeleteDeviceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDevice not implemented")
+}
 func (UnimplementedLocationServiceServer) mustEmbedUnimplementedLocationServiceServer() {}
 func (UnimplementedLocationServiceServer) testEmbeddedByValue()                         {}
 
@@ -104,6 +339,215 @@ func _LocationService_UpdateLocation_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LocationService_SearchUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).SearchUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_SearchUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).SearchUsers(ctx, req.(*SearchUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_GetTravelDistance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TravelDistanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).GetTravelDistance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_GetTravelDistance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).GetTravelDistance(ctx, req.(*TravelDistanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_GetRetentionStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetentionStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).GetRetentionStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_GetRetentionStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).GetRetentionStatus(ctx, req.(*RetentionStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_SetRetentionOverride_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetentionOverride)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).SetRetentionOverride(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_SetRetentionOverride_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).SetRetentionOverride(ctx, req.(*RetentionOverride))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_DeleteRetentionOverride_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRetentionOverrideRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).DeleteRetentionOverride(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_DeleteRetentionOverride_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).DeleteRetentionOverride(ctx, req.(*DeleteRetentionOverrideRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_EraseUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EraseUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).EraseUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_EraseUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).EraseUser(ctx, req.(*EraseUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_ExportUser_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportUserRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LocationServiceServer).ExportUser(m, &grpc.GenericServerStream[ExportUserRequest, ExportUserChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LocationService_ExportUserServer = grpc.ServerStreamingServer[ExportUserChunk]
+
+func _LocationService_ImportLocations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportLocationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).ImportLocations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_ImportLocations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).ImportLocations(ctx, req.(*ImportLocationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_RegisterDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).RegisterDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_RegisterDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).RegisterDevice(ctx, req.(*RegisterDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_GetDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).GetDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_GetDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).GetDevice(ctx, req.(*GetDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LocationService_DeleteDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LocationServiceServer).DeleteDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LocationService_DeleteDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LocationServiceServer).DeleteDevice(ctx, req.(*DeleteDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // LocationService_ServiceDesc is the grpc.ServiceDesc for LocationService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,7 +559,57 @@ var LocationService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateLocation",
 			Handler:    _LocationService_UpdateLocation_Handler,
 		},
+		{
+			MethodName: "SearchUsers",
+			Handler:    _LocationService_SearchUsers_Handler,
+		},
+		{
+			MethodName: "GetTravelDistance",
+			Handler:    _LocationService_GetTravelDistance_Handler,
+		},
+		{
+			MethodName: "GetRetentionStatus",
+			Handler:    _LocationService_GetRetentionStatus_Handler,
+		},
+		{
+			MethodName: "SetRetentionOverride",
+			Handler:    _LocationService_SetRetentionOverride_Handler,
+		},
+		{
+			MethodName: "DeleteRetentionOverride",
+			Handler:    _LocationService_DeleteRetentionOverride_Handler,
+		},
+		{
+			MethodName: "EraseUser",
+			Handler:    _LocationService_EraseUser_Handler,
+		},
+		{
+			MethodName: "ImportLocations",
+			Handler:    _LocationService_ImportLocations_Handler,
+		},
+		{
+			MethodName: "RegisterDevice",
+			Handler:    _LocationService_RegisterDevice_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _LocationService_ListDevices_Handler,
+		},
+		{
+			MethodName: "GetDevice",
+			Handler:    _LocationService_GetDevice_Handler,
+		},
+		{
+			MethodName: "DeleteDevice",
+			Handler:    _LocationService_DeleteDevice_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportUser",
+			Handler:       _LocationService_ExportUser_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "location.proto",
 }
@@ -0,0 +1,146 @@
+package __
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/abotoiGrid/Golang-Project/validation"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Validate methods are checked by validation.UnaryServerInterceptor before a
+// request reaches the LocationService handlers.
+
+func (r *LocationRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.Latitude("latitude", r.Latitude)
+	v.Longitude("longitude", r.Longitude)
+	if r.GetTimestampUnix() == 0 && r.GetTimestamp() != "" {
+		v.UnixTime("timestamp", r.UnixTimestamp())
+	} else {
+		v.UnixTime("timestamp_unix", r.UnixTimestamp())
+	}
+	v.NotNegative("accuracy", r.Accuracy)
+	v.NotNegative("speed", r.Speed)
+	return v.Err()
+}
+
+// UnixTimestamp returns timestamp_unix or, for older clients, the deprecated
+// string timestamp in Unix seconds or RFC 3339. It returns 0 when neither is
+// set or the string cannot be parsed.
+func (r *LocationRequest) UnixTimestamp() int64 {
+	if ts := r.GetTimestampUnix(); ts != 0 {
+		return ts
+	}
+	legacy := r.GetTimestamp()
+	if ts, err := strconv.ParseInt(legacy, 10, 64); err == nil {
+		return ts
+	}
+	if t, err := time.Parse(time.RFC3339, legacy); err == nil {
+		return t.Unix()
+	}
+	return 0
+}
+
+// Validate expects page and page_size to have their defaults applied.
+func (r *SearchUsersRequest) Validate() error {
+	var v validation.Validator
+	v.Latitude("latitude", r.Latitude)
+	v.Longitude("longitude", r.Longitude)
+	v.Positive("radius", r.Radius)
+	v.AtLeast("page", int64(r.GetPage()), 1)
+	v.AtLeast("page_size", int64(r.GetPageSize()), 1)
+	v.OneOf("scope", r.GetScope(), "all", "contacts")
+	return v.Err()
+}
+
+// Validate expects start and end to have their defaults applied.
+func (r *TravelDistanceRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.TimeRange("start", asTime(r.GetStart()), "end", asTime(r.GetEnd()))
+	return v.Err()
+}
+
+// asTime returns the zero time for an unset timestamp, where AsTime would
+// return the Unix epoch.
+func asTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func (r *RetentionOverride) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.AtLeast("retain_days", int64(r.GetRetainDays()), 0)
+	return v.Err()
+}
+
+func (r *DeleteRetentionOverrideRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	return v.Err()
+}
+
+func (r *EraseUserRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	return v.Err()
+}
+
+// Validate is called by the ExportUser handler, since the interceptor only
+// covers unary calls.
+func (r *ExportUserRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	return v.Err()
+}
+
+// MaxImportBatch bounds the locations of one ImportLocations call.
+const MaxImportBatch = 1000
+
+func (r *ImportLocationsRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.AtLeast("locations", int64(len(r.GetLocations())), 1)
+	v.AtMost("locations", int64(len(r.GetLocations())), MaxImportBatch)
+	for i, l := range r.GetLocations() {
+		field := fmt.Sprintf("locations[%d]", i)
+		v.Latitude(field+".latitude", &l.Latitude)
+		v.Longitude(field+".longitude", &l.Longitude)
+		v.UnixTime(field+".timestamp", l.GetTimestamp())
+		v.NotNegative(field+".accuracy", l.Accuracy)
+		v.NotNegative(field+".speed", l.Speed)
+	}
+	return v.Err()
+}
+
+func (r *RegisterDeviceRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.DeviceID("device_id", r.GetDeviceId())
+	return v.Err()
+}
+
+func (r *ListDevicesRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	return v.Err()
+}
+
+func (r *GetDeviceRequest) Validate() error {
+	var v validation.Validator
+	v.DeviceID("device_id", r.GetDeviceId())
+	return v.Err()
+}
+
+func (r *DeleteDeviceRequest) Validate() error {
+	var v validation.Validator
+	v.Username("username", r.GetUsername())
+	v.DeviceID("device_id", r.GetDeviceId())
+	return v.Err()
+}
@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor forwards the request ID in ctx as gRPC metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadata, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// incomingContext returns ctx with the caller's request ID, or a new one
+// when the caller sent none.
+func incomingContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(requestIDMetadata); len(ids) > 0 && ValidRequestID(ids[0]) {
+		return WithRequestID(ctx, ids[0])
+	}
+	return WithRequestID(ctx, NewRequestID())
+}
+
+// logCall writes one line per call. Health checks are only logged at debug
+// level since probes call them constantly.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	level := slog.LevelInfo
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		level = slog.LevelDebug
+	}
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", status.Code(err).String()),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(ctx, level, "gRPC call", attrs...)
+}
+
+// UnaryServerInterceptor attaches the caller's request ID and, for requests
+// that carry one, the username to the context and logs every call.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = incomingContext(ctx)
+	if r, ok := req.(interface{ GetUsername() string }); ok && r.GetUsername() != "" {
+		ctx = WithUsername(ctx, r.GetUsername())
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := incomingContext(ss.Context())
+
+	start := time.Now()
+	err := handler(srv, contextStream{ss, ctx})
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
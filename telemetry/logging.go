@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+)
+
+// RequestIDHeader carries the request ID at the HTTP edge; requestIDMetadata
+// carries it between services.
+const (
+	RequestIDHeader   = "X-Request-ID"
+	requestIDMetadata = "x-request-id"
+	maxRequestIDLen   = 128
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	usernameKey
+)
+
+// InitLogging makes a JSON slog logger the default for both slog and the
+// standard log package. Records logged with a context carry its request ID
+// and username.
+func InitLogging(service string, cfg config.Logging) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return err
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}).With("service", service))
+	return nil
+}
+
+// Fatal logs msg and err at error level and exits.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// contextHandler adds the request ID and username stored in the record's
+// context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if username := Username(ctx); username != "" {
+		r.AddAttrs(slog.String("username", username))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// NewRequestID returns a random 128-bit ID in hex.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a caller-supplied ID is safe to accept:
+// non-empty, bounded in length and limited to printable ASCII without
+// spaces.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, usernameKey, username)
+}
+
+func Username(ctx context.Context) string {
+	username, _ := ctx.Value(usernameKey).(string)
+	return username
+}
@@ -0,0 +1,68 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)})
+
+	ctx := WithUsername(WithRequestID(context.Background(), "req-1"), "testuser")
+	logger.InfoContext(ctx, "hello")
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "testuser", line["username"])
+}
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, ValidRequestID(NewRequestID()))
+	assert.True(t, ValidRequestID("abc-123_XYZ"))
+	assert.False(t, ValidRequestID(""))
+	assert.False(t, ValidRequestID("has space"))
+	assert.False(t, ValidRequestID("line\nbreak"))
+	assert.False(t, ValidRequestID(string(make([]byte, maxRequestIDLen+1))))
+}
+
+type usernameRequest struct{ username string }
+
+func (r usernameRequest) GetUsername() string { return r.username }
+
+func TestRequestIDPropagation(t *testing.T) {
+	// The client side puts the ID into outgoing metadata...
+	var sent metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		sent, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	ctx := WithRequestID(context.Background(), "req-42")
+	assert.NoError(t, UnaryClientInterceptor(ctx, "/test/Method", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"req-42"}, sent.Get(requestIDMetadata))
+
+	// ...and the server side restores it, along with the username.
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Method"}
+	incoming := metadata.NewIncomingContext(context.Background(), sent)
+	_, err := UnaryServerInterceptor(incoming, usernameRequest{"testuser"}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.Equal(t, "req-42", RequestID(ctx))
+		assert.Equal(t, "testuser", Username(ctx))
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	// Calls without an ID get a fresh one.
+	_, err = UnaryServerInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.True(t, ValidRequestID(RequestID(ctx)))
+		return nil, nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,36 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abotoiGrid/Golang-Project/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestInitTracingStdoutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := InitTracing(context.Background(), "test-service", config.Tracing{
+		Exporter:    config.TracingStdout,
+		File:        path,
+		SampleRatio: 1,
+	})
+	assert.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	out, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `"Name":"test-span"`)
+	assert.Contains(t, string(out), "test-service")
+}
+
+func TestInitTracingUnknownExporter(t *testing.T) {
+	_, err := InitTracing(context.Background(), "test-service", config.Tracing{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package validation
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Validatable is implemented by requests that can check their own fields.
+type Validatable interface {
+	Validate() error
+}
+
+// Status converts a validation error into an InvalidArgument status whose
+// details list the invalid fields.
+func Status(err error) error {
+	var errs Errors
+	if !errors.As(err, &errs) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	br := &errdetails.BadRequest{}
+	for _, fe := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       fe.Field,
+			Description: fe.Message,
+		})
+	}
+	st, detailErr := status.New(codes.InvalidArgument, errs.Error()).WithDetails(br)
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, errs.Error())
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor rejects requests whose Validate method fails
+// before they reach the handler.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if v, ok := req.(Validatable); ok {
+		if err := v.Validate(); err != nil {
+			return nil, Status(err)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// FromStatus recovers the field errors that Status attached to st.
+func FromStatus(st *status.Status) (Errors, bool) {
+	var errs Errors
+	for _, d := range st.Details() {
+		br, ok := d.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, fv := range br.GetFieldViolations() {
+			errs = append(errs, FieldError{Field: fv.GetField(), Message: fv.GetDescription()})
+		}
+	}
+	return errs, len(errs) > 0
+}
@@ -0,0 +1,174 @@
+// Package validation checks user input the same way in the HTTP API and the
+// gRPC services, reporting every invalid field with a stable message.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	MsgUsername  = "Invalid username. Must be 4-16 alphanumeric characters"
+	MsgLatitude  = "Invalid latitude. Must be between -90 and 90"
+	MsgLongitude = "Invalid longitude. Must be between -180 and 180"
+	MsgTimeRange = "Invalid time range. Start must not be after end"
+	MsgTimestamp = "Invalid timestamp. Must be a positive Unix time"
+	MsgDeviceID  = "Invalid device ID. Must be 1-64 letters, digits or . _ : -"
+)
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9]{4,16}$`)
+	deviceIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+)
+
+// FieldError is one invalid field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a request.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator collects field errors; Err returns them once all checks ran.
+type Validator struct {
+	errs Errors
+}
+
+func (v *Validator) add(field, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: message})
+}
+
+// Err returns the collected Errors, or nil when every field is valid.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func IsValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// IsValidDeviceID accepts the identifiers trackers report, such as IMEIs.
+func IsValidDeviceID(id string) bool {
+	return deviceIDPattern.MatchString(id)
+}
+
+func IsValidLatitude(lat float64) bool {
+	return lat >= -90 && lat <= 90
+}
+
+func IsValidLongitude(lon float64) bool {
+	return lon >= -180 && lon <= 180
+}
+
+func (v *Validator) Username(field, value string) {
+	if !IsValidUsername(value) {
+		v.add(field, MsgUsername)
+	}
+}
+
+func (v *Validator) DeviceID(field, value string) {
+	if !IsValidDeviceID(value) {
+		v.add(field, MsgDeviceID)
+	}
+}
+
+// Latitude and Longitude treat a nil value as missing, so callers can tell
+// an absent coordinate from 0.
+func (v *Validator) Latitude(field string, value *float64) {
+	switch {
+	case value == nil:
+		v.add(field, MsgLatitude)
+	case !IsValidLatitude(*value):
+		v.add(field, MsgLatitude)
+	}
+}
+
+func (v *Validator) Longitude(field string, value *float64) {
+	switch {
+	case value == nil:
+		v.add(field, MsgLongitude)
+	case !IsValidLongitude(*value):
+		v.add(field, MsgLongitude)
+	}
+}
+
+// Positive treats a nil value as missing, like Latitude.
+func (v *Validator) Positive(field string, value *float64) {
+	if value == nil || *value <= 0 {
+		v.add(field, fmt.Sprintf("Invalid %s. Must be greater than 0", field))
+	}
+}
+
+// NotNegative accepts a missing value, since it is used for optional
+// measurements such as accuracy.
+func (v *Validator) NotNegative(field string, value *float64) {
+	if value != nil && *value < 0 {
+		v.add(field, fmt.Sprintf("Invalid %s. Must not be negative", field))
+	}
+}
+
+// TimeRange requires both ends and start not after end.
+func (v *Validator) TimeRange(startField string, start time.Time, endField string, end time.Time) {
+	if start.IsZero() || end.IsZero() || start.After(end) {
+		v.add(startField, MsgTimeRange)
+	}
+}
+
+// UnixTime requires a positive Unix timestamp in seconds.
+func (v *Validator) UnixTime(field string, value int64) {
+	if value <= 0 {
+		v.add(field, MsgTimestamp)
+	}
+}
+
+// Required requires a non-empty value.
+func (v *Validator) Required(field, value string) {
+	if value == "" {
+		v.add(field, fmt.Sprintf("Invalid %s. Must not be empty", field))
+	}
+}
+
+// Between requires value to be from min to max.
+func (v *Validator) Between(field string, value, min, max float64) {
+	if value < min || value > max {
+		v.add(field, fmt.Sprintf("Invalid %s. Must be between %g and %g", field, min, max))
+	}
+}
+
+// OneOf requires value to be one of allowed.
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, fmt.Sprintf("Invalid %s. Must be one of %s", field, strings.Join(allowed, ", ")))
+}
+
+// AtMost requires value to be max or less.
+func (v *Validator) AtMost(field string, value, max int64) {
+	if value > max {
+		v.add(field, fmt.Sprintf("Invalid %s. Must be at most %d", field, max))
+	}
+}
+
+// AtLeast requires value to be min or more.
+func (v *Validator) AtLeast(field string, value, min int64) {
+	if value < min {
+		v.add(field, fmt.Sprintf("Invalid %s. Must be at least %d", field, min))
+	}
+}
@@ -0,0 +1,98 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	v.Username("username", "testuser")
+	v.DeviceID("device_id", "356938035643809")
+	v.Latitude("latitude", ptr(0))
+	v.Longitude("longitude", ptr(-180))
+	v.TimeRange("start", time.Unix(0, 0), "end", time.Unix(10, 0))
+	v.NotNegative("accuracy", nil)
+	v.NotNegative("speed", ptr(0))
+	v.Required("name", "ci")
+	v.Between("meters", 20000, 0, 20000)
+	assert.NoError(t, v.Err())
+
+	v = Validator{}
+	v.Username("username", "ab")
+	v.Latitude("latitude", ptr(100)) // valid longitude, invalid latitude
+	v.Longitude("longitude", nil)
+	v.Positive("radius", ptr(0))
+	v.TimeRange("start", time.Unix(10, 0), "end", time.Unix(0, 0))
+	v.UnixTime("timestamp", 0)
+	v.OneOf("scope", "friends", "all", "contacts")
+	v.AtLeast("page", 0, 1)
+	v.AtMost("page_size", 101, 100)
+	v.DeviceID("device_id", "tracker 1")
+	v.NotNegative("accuracy", ptr(-1))
+	v.Required("name", "")
+	v.Between("meters", -1, 0, 20000)
+
+	var errs Errors
+	assert.True(t, errors.As(v.Err(), &errs))
+	assert.Equal(t, Errors{
+		{Field: "username", Message: MsgUsername},
+		{Field: "latitude", Message: MsgLatitude},
+		{Field: "longitude", Message: MsgLongitude},
+		{Field: "radius", Message: "Invalid radius. Must be greater than 0"},
+		{Field: "start", Message: MsgTimeRange},
+		{Field: "timestamp", Message: MsgTimestamp},
+		{Field: "scope", Message: "Invalid scope. Must be one of all, contacts"},
+		{Field: "page", Message: "Invalid page. Must be at least 1"},
+		{Field: "page_size", Message: "Invalid page_size. Must be at most 100"},
+		{Field: "device_id", Message: MsgDeviceID},
+		{Field: "accuracy", Message: "Invalid accuracy. Must not be negative"},
+		{Field: "name", Message: "Invalid name. Must not be empty"},
+		{Field: "meters", Message: "Invalid meters. Must be between 0 and 20000"},
+	}, errs)
+	assert.Contains(t, errs.Error(), MsgUsername+"; "+MsgLatitude)
+
+	// A missing value is not positive either
+	v = Validator{}
+	v.Positive("radius", nil)
+	assert.EqualError(t, v.Err(), "Invalid radius. Must be greater than 0")
+}
+
+type testRequest struct{ username string }
+
+func (r testRequest) Validate() error {
+	var v Validator
+	v.Username("username", r.username)
+	return v.Err()
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	resp, err := UnaryServerInterceptor(context.Background(), testRequest{"testuser"}, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = UnaryServerInterceptor(context.Background(), testRequest{"x"}, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, MsgUsername, st.Message())
+	assert.Len(t, st.Details(), 1)
+	br := st.Details()[0].(*errdetails.BadRequest)
+	assert.Equal(t, "username", br.FieldViolations[0].Field)
+
+	errs, ok := FromStatus(st)
+	assert.True(t, ok)
+	assert.Equal(t, Errors{{Field: "username", Message: MsgUsername}}, errs)
+}